@@ -4,52 +4,24 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+	"github.com/lmLumos/nscon/manager"
+	"github.com/lmLumos/nscon/mapping"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
-	"time"
 )
 
-// ControllerManager manages multiple Nintendo Switch controllers with separate USB gadgets
-type ControllerManager struct {
-	controllers map[int]*nscon.Controller
-	devices     map[int]string
-	hidgDevices map[int]string
-	mutex       sync.RWMutex
-	logLevel    int
-	running     bool
-}
-
-// NewControllerManager creates a new multi-controller manager
-func NewControllerManager(logLevel int) *ControllerManager {
-	return &ControllerManager{
-		controllers: make(map[int]*nscon.Controller),
-		devices:     make(map[int]string),
-		hidgDevices: make(map[int]string),
-		logLevel:    logLevel,
-		running:     true,
-	}
-}
-
-// AddController adds a new controller with the given player number (1-8)
-func (cm *ControllerManager) AddController(playerNum int, hidgDevice string, inputDevice string) error {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	if playerNum < 1 || playerNum > 8 {
-		return fmt.Errorf("player number must be between 1-8, got %d", playerNum)
-	}
-
-	if _, exists := cm.controllers[playerNum]; exists {
-		return fmt.Errorf("controller %d already exists", playerNum)
-	}
-
+// addController connects a controller on hidgDevice to the slot of playerNum (1-8),
+// fed by the input events of inputDevice
+func addController(m *manager.Manager, playerNum int, hidgDevice string, inputDevice string, logLevel int) error {
 	// Verify hidg device exists
 	if _, err := os.Stat(hidgDevice); os.IsNotExist(err) {
 		return fmt.Errorf("hidg device %s does not exist", hidgDevice)
@@ -60,245 +32,48 @@ func (cm *ControllerManager) AddController(playerNum int, hidgDevice string, inp
 		return fmt.Errorf("input device %s does not exist", inputDevice)
 	}
 
-	// Create new Nintendo Switch controller
-	controller := nscon.NewController(hidgDevice)
-	controller.LogLevel = cm.logLevel
-
-	// Connect to the Nintendo Switch
-	err := controller.Connect()
+	// Connect to the Nintendo Switch and start reading input
+	controller := newController(playerNum, hidgDevice, logLevel)
+	err := m.Add(playerNum, controller, newSource(controller.Logger, inputDevice))
 	if err != nil {
-		return fmt.Errorf("failed to connect controller %d to %s: %v", playerNum, hidgDevice, err)
+		return fmt.Errorf("failed to connect controller %d to %s: %w", playerNum, hidgDevice, err)
 	}
 
-	cm.controllers[playerNum] = controller
-	cm.devices[playerNum] = inputDevice
-	cm.hidgDevices[playerNum] = hidgDevice
-
 	log.Printf("Controller %d connected: %s -> %s", playerNum, inputDevice, hidgDevice)
-
-	// Start reading input for this controller in a goroutine
-	go cm.readControllerInput(playerNum, inputDevice, controller)
-
 	return nil
 }
 
-// RemoveController removes a controller
-func (cm *ControllerManager) RemoveController(playerNum int) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	if controller, exists := cm.controllers[playerNum]; exists {
-		controller.Close()
-		delete(cm.controllers, playerNum)
-		delete(cm.devices, playerNum)
-		delete(cm.hidgDevices, playerNum)
-		log.Printf("Controller %d disconnected", playerNum)
-	}
-}
-
-// Close closes all controllers
-func (cm *ControllerManager) Close() {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	cm.running = false
-
-	for playerNum, controller := range cm.controllers {
-		controller.Close()
-		log.Printf("Controller %d closed", playerNum)
-	}
-
-	cm.controllers = make(map[int]*nscon.Controller)
-	cm.devices = make(map[int]string)
-	cm.hidgDevices = make(map[int]string)
+// newController creates the Nintendo Switch controller of playerNum on hidgDevice
+func newController(playerNum int, hidgDevice string, logLevel int) *nscon.Controller {
+	controller := nscon.NewController(hidgDevice)
+	controller.Logger = newLogger(playerNum, logLevel)
+	return controller
 }
 
-// ListControllers returns a list of active controllers
-func (cm *ControllerManager) ListControllers() []int {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-
-	var players []int
-	for playerNum := range cm.controllers {
-		players = append(players, playerNum)
+// newLogger creates the logger of playerNum, logging debug messages above logLevel 2
+func newLogger(playerNum int, logLevel int) *slog.Logger {
+	level := slog.LevelInfo
+	if logLevel > 2 {
+		level = slog.LevelDebug
 	}
-	return players
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})).
+		With("player", playerNum)
 }
 
-func (cm *ControllerManager) readControllerInput(playerNum int, devicePath string, con *nscon.Controller) {
-	file, err := os.Open(devicePath)
-	if err != nil {
-		log.Printf("Controller %d: Failed to open device %s: %v", playerNum, devicePath, err)
-		return
-	}
-	defer file.Close()
-
-	log.Printf("Controller %d: Reading input events from %s", playerNum, devicePath)
-
-	// Buffer for input_event struct (24 bytes on 64-bit systems)
-	eventSize := 24
-	buffer := make([]byte, eventSize)
-
-	for cm.running {
-		// Check if controller still exists
-		cm.mutex.RLock()
-		_, exists := cm.controllers[playerNum]
-		cm.mutex.RUnlock()
+// mappings holds the flags of mapping.FlagsUsage
+var mappings mapping.Mappings
 
-		if !exists {
-			log.Printf("Controller %d: Stopping input reader", playerNum)
-			return
-		}
-
-		// Set read timeout to allow for clean shutdown
-		file.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-
-		n, err := file.Read(buffer)
-		if err != nil {
-			// Check if it's a timeout error (expected for clean shutdown)
-			if strings.Contains(err.Error(), "timeout") {
-				continue
-			}
-			log.Printf("Controller %d: Error reading from device: %v", playerNum, err)
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
+// slotsPath stores the player slot of each controller in --auto mode, so
+// that the same controller gets the same player after a restart
+var slotsPath = "/var/lib/nscon/slots.json"
 
-		if n != eventSize {
-			continue
-		}
+// grabDevices takes the input devices exclusively with --grab, so that the
+// console or desktop doesn't react to the controllers too
+var grabDevices = false
 
-		// Parse the input_event structure
-		eventType := uint16(buffer[16]) | uint16(buffer[17])<<8
-		code := uint16(buffer[18]) | uint16(buffer[19])<<8
-		value := int32(buffer[20]) | int32(buffer[21])<<8 | int32(buffer[22])<<16 | int32(buffer[23])<<24
-
-		// Handle input event
-		cm.handleInputEvent(playerNum, eventType, code, value, con)
-	}
-}
-
-func (cm *ControllerManager) handleInputEvent(playerNum int, eventType uint16, code uint16, value int32, con *nscon.Controller) {
-	const (
-		EV_KEY = 1 // Button events
-		EV_ABS = 3 // Absolute axis events
-		EV_SYN = 0 // Sync events
-	)
-
-	switch eventType {
-	case EV_KEY:
-		pressed := value > 0
-
-		switch code {
-		case 304: // BTN_SOUTH (A)
-			setInput(&con.Input.Button.A, pressed)
-		case 305: // BTN_EAST (B)
-			setInput(&con.Input.Button.B, pressed)
-		case 307: // BTN_NORTH (Y)
-			setInput(&con.Input.Button.Y, pressed)
-		case 308: // BTN_WEST (X)
-			setInput(&con.Input.Button.X, pressed)
-		case 310: // BTN_TL (L)
-			setInput(&con.Input.Button.L, pressed)
-		case 311: // BTN_TR (R)
-			setInput(&con.Input.Button.R, pressed)
-		case 312: // BTN_TL2 (ZL)
-			setInput(&con.Input.Button.ZL, pressed)
-		case 313: // BTN_TR2 (ZR)
-			setInput(&con.Input.Button.ZR, pressed)
-		case 314: // BTN_SELECT (Minus)
-			setInput(&con.Input.Button.Minus, pressed)
-		case 315: // BTN_START (Plus)
-			setInput(&con.Input.Button.Plus, pressed)
-		case 316: // BTN_MODE (Home)
-			setInput(&con.Input.Button.Home, pressed)
-		case 317: // BTN_THUMBL (Left stick press)
-			con.Input.Stick.Left.Press = uint8(value)
-		case 318: // BTN_THUMBR (Right stick press)
-			con.Input.Stick.Right.Press = uint8(value)
-		}
-
-		if cm.logLevel > 2 {
-			log.Printf("Controller %d: Button event - Code: %d, Pressed: %t", playerNum, code, pressed)
-		}
-
-	case EV_ABS:
-		// Debug output to see raw values
-		if cm.logLevel > 2 {
-			log.Printf("Controller %d: Axis event - Code: %d, Raw Value: %d", playerNum, code, value)
-		}
-
-		// Normalize axis values for different controller types
-		var normalizedValue float64
-
-		// Handle different controller ranges
-		if value >= 0 && value <= 255 {
-			// 8-bit unsigned range (0-255)
-			normalizedValue = (float64(value) - 127.5) / 127.5
-		} else if value >= -32768 && value <= 32767 {
-			// 16-bit signed range (-32768 to 32767)
-			normalizedValue = float64(value) / 32767.0
-		} else if value >= 0 && value <= 4095 {
-			// 12-bit unsigned range
-			normalizedValue = (float64(value) - 2048.0) / 2048.0
-		} else {
-			// Fallback to 8-bit unsigned
-			normalizedValue = (float64(value) - 127.5) / 127.5
-		}
-
-		// Clamp to valid range
-		if normalizedValue > 1.0 {
-			normalizedValue = 1.0
-		} else if normalizedValue < -1.0 {
-			normalizedValue = -1.0
-		}
-
-		// Apply deadzone
-		if normalizedValue > -0.05 && normalizedValue < 0.05 {
-			normalizedValue = 0.0
-		}
-
-		switch code {
-		case 0: // ABS_X (Left stick X)
-			con.Input.Stick.Left.X = normalizedValue
-		case 1: // ABS_Y (Left stick Y)
-			con.Input.Stick.Left.Y = -normalizedValue // Invert Y
-		case 3: // ABS_RX (Right stick X)
-			con.Input.Stick.Right.X = normalizedValue
-		case 4: // ABS_RY (Right stick Y)
-			con.Input.Stick.Right.Y = -normalizedValue // Invert Y
-		case 16: // ABS_HAT0X (D-pad horizontal)
-			if value < 0 {
-				con.Input.Dpad.Left = 1
-				con.Input.Dpad.Right = 0
-			} else if value > 0 {
-				con.Input.Dpad.Left = 0
-				con.Input.Dpad.Right = 1
-			} else {
-				con.Input.Dpad.Left = 0
-				con.Input.Dpad.Right = 0
-			}
-		case 17: // ABS_HAT0Y (D-pad vertical)
-			if value < 0 {
-				con.Input.Dpad.Up = 1
-				con.Input.Dpad.Down = 0
-			} else if value > 0 {
-				con.Input.Dpad.Up = 0
-				con.Input.Dpad.Down = 1
-			} else {
-				con.Input.Dpad.Up = 0
-				con.Input.Dpad.Down = 0
-			}
-		}
-	}
-}
-
-func setInput(input *uint8, pressed bool) {
-	if pressed {
-		*input = 1
-	} else {
-		*input = 0
-	}
+// newSource reads the input device at path, logging to logger
+func newSource(logger *slog.Logger, path string) manager.Source {
+	return manager.Gamepad{Path: path, Mappings: &mappings, Grab: grabDevices, Logger: logger}
 }
 
 // findInputDevices automatically detects connected controllers
@@ -313,8 +88,8 @@ func findInputDevices() map[string]string {
 			namePath := fmt.Sprintf("/sys/class/input/event%d/device/name", i)
 			if nameBytes, err := os.ReadFile(namePath); err == nil {
 				name := strings.TrimSpace(string(nameBytes))
-				// Look for common controller names
-				if isControllerDevice(name) {
+				// Look for joysticks and gamepads
+				if isControllerDevice(path) {
 					key := fmt.Sprintf("%s%d", name, i)
 					devices[key] = path
 					log.Printf("Found controller: %s at %s", key, path)
@@ -326,31 +101,16 @@ func findInputDevices() map[string]string {
 	return devices
 }
 
-// isControllerDevice checks if a device name indicates a game controller
-func isControllerDevice(name string) bool {
-	controllerNames := []string{
-		"Wireless Controller",
-		"Xbox",
-		"PlayStation",
-		"PS3",
-		"PS4",
-		"PS5",
-		"DualShock",
-		"DualSense",
-		"Pro Controller",
-		"Joy-Con",
-		"8Bitdo",
-		"Nintendo",
-		"Gamepad",
+// isControllerDevice checks if a device has the buttons of a joystick or gamepad
+func isControllerDevice(path string) bool {
+	device, err := evdev.Open(path)
+	if err != nil {
+		return false
 	}
+	defer device.Close()
 
-	nameLower := strings.ToLower(name)
-	for _, controllerName := range controllerNames {
-		if nameLower== strings.ToLower(controllerName) {
-			return true
-		}
-	}
-	return false
+	caps, err := device.Capabilities()
+	return err == nil && caps.IsJoystick()
 }
 
 // findHidgDevices finds available hidg devices
@@ -366,7 +126,7 @@ func findHidgDevices() []string {
 }
 
 // setupControllerMapping provides interactive controller setup
-func setupControllerMapping(manager *ControllerManager) {
+func setupControllerMapping(m *manager.Manager, logLevel int) {
 	fmt.Println("\n=== Controller Mapping Setup ===")
 	
 	inputDevices := findInputDevices()
@@ -427,7 +187,7 @@ func setupControllerMapping(manager *ControllerManager) {
 
 		fmt.Printf("Mapping: Player %d = %s -> %s\n", playerNum, inputDevice, hidgDevice)
 		
-		err = manager.AddController(playerNum, hidgDevice, inputDevice)
+		err = addController(m, playerNum, hidgDevice, inputDevice, logLevel)
 		if err != nil {
 			fmt.Printf("❌ Failed to add controller %d: %v\n", playerNum, err)
 			continue
@@ -438,12 +198,12 @@ func setupControllerMapping(manager *ControllerManager) {
 		playerNum++
 	}
 
-	if len(manager.ListControllers()) == 0 {
+	if len(m.Players()) == 0 {
 		fmt.Println("❌ No controllers were successfully configured!")
 		return
 	}
 
-	fmt.Printf("\n✅ Successfully configured %d controller(s)!\n", len(manager.ListControllers()))
+	fmt.Printf("\n✅ Successfully configured %d controller(s)!\n", len(m.Players()))
 }
 
 func printUsage() {
@@ -454,9 +214,12 @@ func printUsage() {
 	fmt.Println("  sudo go run improved_multi_controller.go [options]")
 	fmt.Println("")
 	fmt.Println("Options:")
-	fmt.Println("  --auto          Auto-detect and map controllers")
+	fmt.Println("  --auto          Attach controllers as they are plugged in")
 	fmt.Println("  --interactive   Interactive controller setup (default)")
 	fmt.Println("  --manual        Manual controller configuration")
+	fmt.Println(mapping.FlagsUsage)
+	fmt.Println("  --slots=FILE    Remember player slots in FILE (default /var/lib/nscon/slots.json, empty to disable)")
+	fmt.Println("  --grab          Take the input devices exclusively")
 	fmt.Println("  --debug         Enable debug logging")
 	fmt.Println("  --help, -h      Show this help")
 	fmt.Println("")
@@ -481,7 +244,11 @@ func main() {
 	manualMode := false
 	debugMode := false
 
-	for _, arg := range os.Args[1:] {
+	args, err := mappings.ParseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load mappings: %v", err)
+	}
+	for _, arg := range args {
 		switch arg {
 		case "--auto":
 			autoMode = true
@@ -492,6 +259,12 @@ func main() {
 			autoMode = false
 		case "--debug":
 			debugMode = true
+		case "--grab":
+			grabDevices = true
+		default:
+			if path, ok := strings.CutPrefix(arg, "--slots="); ok {
+				slotsPath = path
+			}
 		}
 	}
 
@@ -502,45 +275,45 @@ func main() {
 	}
 
 	// Create controller manager
-	manager := NewControllerManager(logLevel)
-	defer manager.Close()
+	m := manager.New()
+	defer m.Close()
 
 	fmt.Println("🎮 Multi-Controller Nintendo Switch Simulator")
 	fmt.Println("Using separate USB gadgets for true multi-controller support")
 	fmt.Println()
 
 	if autoMode {
-		// Auto-detect mode
-		fmt.Println("🔍 Auto-detecting controllers...")
-		
-		inputDevices := findInputDevices()
-		hidgDevices := findHidgDevices()
-
-		if len(inputDevices) == 0 {
-			fmt.Println("❌ No controllers found!")
-			return
+		// Auto mode: attach controllers as they are plugged in
+		var slots *manager.Slots
+		if slotsPath != "" {
+			var err error
+			if slots, err = manager.LoadSlots(slotsPath); err != nil {
+				log.Printf("Failed to load player slots: %v", err)
+				return
+			}
 		}
-
-		if len(hidgDevices) == 0 {
-			fmt.Println("❌ No hidg devices found! Run setup script first.")
-			return
+		fmt.Println("🔍 Watching for controllers and hidg devices...")
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		h := &manager.Hotplug{
+			Manager: m,
+			Slots:   slots,
+			NewSource: func(player int, node string) manager.Source {
+				return newSource(newLogger(player, logLevel), node)
+			},
+			NewController: func(player int, hidg string) *nscon.Controller {
+				return newController(player, hidg, logLevel)
+			},
 		}
 
-		// Auto-map controllers
-		playerNum := 1
-		for _, inputDevice := range inputDevices {
-			if playerNum > len(hidgDevices) {
-				break
-			}
-			
-			hidgDevice := hidgDevices[playerNum-1]
-			err := manager.AddController(playerNum, hidgDevice, inputDevice)
-			if err != nil {
-				log.Printf("Failed to add controller %d: %v", playerNum, err)
-			} else {
-				playerNum++
-			}
+		fmt.Println("🔌 Connect your Nintendo Switch via USB cable")
+		fmt.Println("🎮 Plug in controllers at any time! Press Ctrl+C to exit.")
+		if err := h.Run(ctx); err != nil {
+			log.Printf("Hotplug failed: %v", err)
 		}
+		fmt.Println("\n🛑 Shutting down all controllers...")
+		return
 
 	} else if manualMode {
 		// Manual mode
@@ -576,7 +349,7 @@ func main() {
 			inputDevice := parts[1]
 			hidgDevice := parts[2]
 
-			err = manager.AddController(playerNum, hidgDevice, inputDevice)
+			err = addController(m, playerNum, hidgDevice, inputDevice, logLevel)
 			if err != nil {
 				fmt.Printf("Failed to add controller: %v\n", err)
 			}
@@ -584,11 +357,11 @@ func main() {
 
 	} else if !autoMode && !manualMode {
 		// Interactive mode (default)
-		setupControllerMapping(manager)
+		setupControllerMapping(m, logLevel)
 	}
 
 	// Show active controllers
-	controllers := manager.ListControllers()
+	controllers := m.Players()
 	if len(controllers) == 0 {
 		fmt.Println("❌ No controllers active. Exiting...")
 		return
@@ -6,284 +6,86 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+	"github.com/lmLumos/nscon/manager"
+	"github.com/lmLumos/nscon/mapping"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 )
 
-// ControllerManager manages multiple Nintendo Switch controllers
-type ControllerManager struct {
-	controllers map[int]*nscon.Controller
-	devices     map[int]string
-	mutex       sync.RWMutex
-	logLevel    int
-}
-
-// NewControllerManager creates a new multi-controller manager
-func NewControllerManager(logLevel int) *ControllerManager {
-	return &ControllerManager{
-		controllers: make(map[int]*nscon.Controller),
-		devices:     make(map[int]string),
-		logLevel:    logLevel,
-	}
-}
-
-// AddController adds a new controller with the given player number (1-8)
-func (cm *ControllerManager) AddController(playerNum int, hidgDevice string, inputDevice string) error {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	if playerNum < 1 || playerNum > 8 {
-		return fmt.Errorf("player number must be between 1-8, got %d", playerNum)
-	}
-
-	if _, exists := cm.controllers[playerNum]; exists {
-		return fmt.Errorf("controller %d already exists", playerNum)
-	}
-
+// addController connects a controller on hidgDevice to the slot of playerNum (1-8),
+// fed by the input events of inputDevice
+func addController(m *manager.Manager, playerNum int, hidgDevice string, inputDevice string, logLevel int) error {
 	// Create new Nintendo Switch controller
 	controller := nscon.NewController(hidgDevice)
-	controller.LogLevel = cm.logLevel
+	level := slog.LevelInfo
+	if logLevel > 2 {
+		level = slog.LevelDebug
+	}
+	controller.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})).
+		With("player", playerNum)
 
-	// Connect to the Nintendo Switch
-	err := controller.Connect()
+	// Connect to the Nintendo Switch and start reading input
+	src := manager.Gamepad{Path: inputDevice, Mappings: &mappings, Grab: grabDevices, Logger: controller.Logger}
+	err := m.Add(playerNum, controller, src)
 	if err != nil {
-		return fmt.Errorf("failed to connect controller %d: %v", playerNum, err)
+		return fmt.Errorf("failed to connect controller %d to %s: %w", playerNum, hidgDevice, err)
 	}
 
-	cm.controllers[playerNum] = controller
-	cm.devices[playerNum] = inputDevice
-
 	log.Printf("Controller %d connected: %s -> %s", playerNum, inputDevice, hidgDevice)
-
-	// Start reading input for this controller in a goroutine
-	go cm.readControllerInput(playerNum, inputDevice, controller)
-
 	return nil
 }
 
-// RemoveController removes a controller
-func (cm *ControllerManager) RemoveController(playerNum int) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	if controller, exists := cm.controllers[playerNum]; exists {
-		controller.Close()
-		delete(cm.controllers, playerNum)
-		delete(cm.devices, playerNum)
-		log.Printf("Controller %d disconnected", playerNum)
-	}
-}
-
-// Close closes all controllers
-func (cm *ControllerManager) Close() {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	for playerNum, controller := range cm.controllers {
-		controller.Close()
-		log.Printf("Controller %d closed", playerNum)
-	}
-	
-	cm.controllers = make(map[int]*nscon.Controller)
-	cm.devices = make(map[int]string)
-}
+// mappings holds the flags of mapping.FlagsUsage. Its SDL mappings also
+// identify the controllers.
+var mappings mapping.Mappings
 
-// ListControllers returns a list of active controllers
-func (cm *ControllerManager) ListControllers() []int {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
+// findAttempts is the number of times --auto looks for controllers
+const findAttempts = 4
 
-	var players []int
-	for playerNum := range cm.controllers {
-		players = append(players, playerNum)
-	}
-	return players
-}
+// grabDevices takes the input devices exclusively with --grab, so that the
+// console or desktop doesn't react to the controllers too
+var grabDevices = false
 
-func (cm *ControllerManager) readControllerInput(playerNum int, devicePath string, con *nscon.Controller) {
-	file, err := os.Open(devicePath)
+// findInputDevices returns the event devices of the connected joysticks and
+// gamepads, and of the devices the SDL mappings know
+func findInputDevices() []string {
+	paths, err := filepath.Glob("/dev/input/event*")
 	if err != nil {
-		log.Printf("Controller %d: Failed to open device %s: %v", playerNum, devicePath, err)
-		return
-	}
-	defer file.Close()
-
-	log.Printf("Controller %d: Reading input events from %s", playerNum, devicePath)
-
-	// Buffer for input_event struct (24 bytes on 64-bit systems)
-	eventSize := 24
-	buffer := make([]byte, eventSize)
-
-	for {
-		// Check if controller still exists
-		cm.mutex.RLock()
-		_, exists := cm.controllers[playerNum]
-		cm.mutex.RUnlock()
-		
-		if !exists {
-			log.Printf("Controller %d: Stopping input reader", playerNum)
-			return
-		}
-
-		n, err := file.Read(buffer)
-		if err != nil {
-			log.Printf("Controller %d: Error reading from device: %v", playerNum, err)
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-
-		if n != eventSize {
-			continue
-		}
-
-		// Parse the input_event structure
-		eventType := uint16(buffer[16]) | uint16(buffer[17])<<8
-		code := uint16(buffer[18]) | uint16(buffer[19])<<8
-		value := int32(buffer[20]) | int32(buffer[21])<<8 | int32(buffer[22])<<16 | int32(buffer[23])<<24
-
-		// Handle input event
-		cm.handleInputEvent(playerNum, eventType, code, value, con)
+		return nil
 	}
-}
-
-func (cm *ControllerManager) handleInputEvent(playerNum int, eventType uint16, code uint16, value int32, con *nscon.Controller) {
-	const (
-		EV_KEY = 1 // Button events
-		EV_ABS = 3 // Absolute axis events
-		EV_SYN = 0 // Sync events
-	)
-
-	switch eventType {
-	case EV_KEY:
-		pressed := value > 0
-
-		switch code {
-		case 304: // BTN_SOUTH (A)
-			setInput(&con.Input.Button.B, pressed)
-		case 305: // BTN_EAST (B)
-			setInput(&con.Input.Button.A, pressed)
-		case 307: // BTN_NORTH (Y)
-			setInput(&con.Input.Button.X, pressed)
-		case 308: // BTN_WEST (X)
-			setInput(&con.Input.Button.Y, pressed)
-		case 310: // BTN_TL (L)
-			setInput(&con.Input.Button.L, pressed)
-		case 311: // BTN_TR (R)
-			setInput(&con.Input.Button.R, pressed)
-		case 312: // BTN_TL2 (ZL)
-			setInput(&con.Input.Button.ZL, pressed)
-		case 313: // BTN_TR2 (ZR)
-			setInput(&con.Input.Button.ZR, pressed)
-		case 314: // BTN_SELECT (Minus)
-			setInput(&con.Input.Button.Minus, pressed)
-		case 315: // BTN_START (Plus)
-			setInput(&con.Input.Button.Plus, pressed)
-		case 316: // BTN_MODE (Home)
-			setInput(&con.Input.Button.Home, pressed)
-		case 317: // BTN_THUMBL (Left stick press)
-			con.Input.Stick.Left.Press = uint8(value)
-		case 318: // BTN_THUMBR (Right stick press)
-			con.Input.Stick.Right.Press = uint8(value)
-		}
-
-
-	case EV_ABS:
-		// Debug output to see raw values
-
-		// Normalize axis values for 8-bit controllers (0-255 range)
-		var normalizedValue float64
-
-		if value >= 0 && value <= 255 {
-			normalizedValue = (float64(value) - 127.5) / 127.5
-		} else if value >= -32768 && value <= 32767 {
-			normalizedValue = float64(value) / 32767.0
-		} else {
-			normalizedValue = (float64(value) - 127.5) / 127.5
-		}
-
-		// Clamp to valid range
-		if normalizedValue > 1.0 {
-			normalizedValue = 1.0
-		} else if normalizedValue < -1.0 {
-			normalizedValue = -1.0
-		}
-
-		// Apply deadzone
-		if normalizedValue > -0.05 && normalizedValue < 0.05 {
-			normalizedValue = 0.0
-		}
-
-		switch code {
-		case 0: // ABS_X (Left stick X)
-			con.Input.Stick.Left.X = normalizedValue
-		case 1: // ABS_Y (Left stick Y)
-			con.Input.Stick.Left.Y = -normalizedValue // Invert Y
-		case 3: // ABS_RX (Right stick X)
-			con.Input.Stick.Right.X = normalizedValue
-		case 4: // ABS_RY (Right stick Y)
-			con.Input.Stick.Right.Y = -normalizedValue // Invert Y
-		case 16: // ABS_HAT0X (D-pad horizontal)
-			if value < 0 {
-				con.Input.Dpad.Left = 1
-				con.Input.Dpad.Right = 0
-			} else if value > 0 {
-				con.Input.Dpad.Left = 0
-				con.Input.Dpad.Right = 1
-			} else {
-				con.Input.Dpad.Left = 0
-				con.Input.Dpad.Right = 0
-			}
-		case 17: // ABS_HAT0Y (D-pad vertical)
-			if value < 0 {
-				con.Input.Dpad.Up = 1
-				con.Input.Dpad.Down = 0
-			} else if value > 0 {
-				con.Input.Dpad.Up = 0
-				con.Input.Dpad.Down = 1
-			} else {
-				con.Input.Dpad.Up = 0
-				con.Input.Dpad.Down = 0
-			}
+	var devices []string
+	for _, path := range paths {
+		if isControllerDevice(path) {
+			devices = append(devices, path)
 		}
 	}
+	sort.Strings(devices)
+	return devices
 }
 
-func setInput(input *uint8, pressed bool) {
-	if pressed {
-		*input = 1
-	} else {
-		*input = 0
+// isControllerDevice checks if a device has the buttons of a joystick or
+// gamepad, or has an SDL mapping
+func isControllerDevice(path string) bool {
+	device, err := evdev.Open(path)
+	if err != nil {
+		return false
 	}
-}
-
-// findInputDevices automatically detects connected controllers
-func findInputDevices() map[int]string {
-	devices := make(map[int]string)
-
-	for i := 0; i < 10; i++ {
-		path := fmt.Sprintf("/dev/input/event%d", i)
-		if _, err := os.Stat(path); err == nil {
-			// Check the sysfs "name" file for this event device
-			namePath := fmt.Sprintf("/sys/class/input/event%d/device/name", i)
-			nameBytes, err := os.ReadFile(namePath)
-			if err != nil {
-				continue
-			}
+	defer device.Close()
 
-			name := strings.TrimSpace(string(nameBytes))
-			if name == "Wireless Controller" {
-				devices[len(devices)+1] = path
-			}
-		}
+	if caps, err := device.Capabilities(); err == nil && caps.IsJoystick() {
+		return true
 	}
-
-	return devices
+	id, err := device.ID()
+	return err == nil && mappings.SDL != nil && mappings.SDL.Lookup(id) != nil
 }
 
 // setupUSBGadgets creates the necessary USB gadget devices
@@ -316,6 +118,8 @@ func printUsage() {
 	fmt.Println("Options:")
 	fmt.Println("  --auto          Auto-detect controllers")
 	fmt.Println("  --manual        Manual controller setup")
+	fmt.Println(mapping.FlagsUsage)
+	fmt.Println("  --grab          Take the input devices exclusively")
 	fmt.Println("  --debug         Enable debug logging")
 	fmt.Println("  --help, -h      Show this help")
 	fmt.Println("")
@@ -336,7 +140,11 @@ func main() {
 	manualMode := false
 	debugMode := false
 
-	for _, arg := range os.Args[1:] {
+	args, err := mappings.ParseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load mappings: %v", err)
+	}
+	for _, arg := range args {
 		switch arg {
 		case "--auto":
 			autoMode = true
@@ -344,6 +152,8 @@ func main() {
 			manualMode = true
 		case "--debug":
 			debugMode = true
+		case "--grab":
+			grabDevices = true
 		}
 	}
 
@@ -358,35 +168,25 @@ func main() {
 	}
 
 	// Create controller manager
-	manager := NewControllerManager(logLevel)
-	defer manager.Close()
+	m := manager.New()
+	defer m.Close()
 
 	if autoMode {
 		// Auto-detect mode
 		log.Println("Auto-detecting controllers...")
 		
+		// Give controllers still pairing a few chances to show up
 		inputDevices := findInputDevices()
-		time.Sleep(5 * time.Second)
-		if len(inputDevices) == 0 {
+		for attempt := 1; len(inputDevices) == 0 && attempt < findAttempts; attempt++ {
 			log.Println("No input devices found!")
 			log.Println("Make sure your controllers are connected.")
-		time.Sleep(5 * time.Second)
+			time.Sleep(5 * time.Second)
+			inputDevices = findInputDevices()
 		}
 		if len(inputDevices) == 0 {
-                        log.Println("No input devices found!")
-                        log.Println("Make sure your controllers are connected.")
-                        time.Sleep(5 * time.Second)
-                }
-		if len(inputDevices) == 0 {
-                        log.Println("No input devices found!")
-                        log.Println("Make sure your controllers are connected.")
-                        time.Sleep(5 * time.Second)
-                }
-		if len(inputDevices) == 0 {
-                        log.Println("No input devices found!")
-                        log.Println("Make sure your controllers are connected.")
-                        return
-                }
+			log.Println("No input devices found!")
+			return
+		}
 
 
 		log.Printf("Found %d potential input device(s)", len(inputDevices))
@@ -401,7 +201,7 @@ func main() {
 				break
 			}
 			
-			err := manager.AddController(playerNum, hidgDevices[playerNum-1], inputDevice)
+			err := addController(m, playerNum, hidgDevices[playerNum-1], inputDevice, logLevel)
 			if err != nil {
 				log.Printf("Failed to add controller %d: %v", playerNum, err)
 			} else {
@@ -443,7 +243,7 @@ func main() {
 			inputDevice := parts[1]
 			hidgDevice := parts[2]
 
-			err = manager.AddController(playerNum, hidgDevice, inputDevice)
+			err = addController(m, playerNum, hidgDevice, inputDevice, logLevel)
 			if err != nil {
 				log.Printf("Failed to add controller: %v", err)
 			}
@@ -451,7 +251,7 @@ func main() {
 	}
 
 	// Show active controllers
-	controllers := manager.ListControllers()
+	controllers := m.Players()
 	if len(controllers) == 0 {
 		log.Println("No controllers active. Exiting...")
 		return
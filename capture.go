@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package nscon
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// Direction is the direction of a report, named from the host's point of view as in USB
+type Direction int
+
+const (
+	// DirIn is a report sent from the controller to the host
+	DirIn Direction = iota
+	// DirOut is a report sent from the host to the controller
+	DirOut
+)
+
+func (d Direction) String() string {
+	if d == DirOut {
+		return "out"
+	}
+	return "in"
+}
+
+// Tap receives a copy of every report exchanged with the host.
+// Report may be called concurrently and must not retain data.
+type Tap interface {
+	Report(dir Direction, t time.Time, data []byte)
+}
+
+const (
+	pcapngBlockSHB = 0x0a0d0d0a
+	pcapngBlockIDB = 0x00000001
+	pcapngBlockEPB = 0x00000006
+
+	// LINKTYPE_USB_LINUX_MMAPPED, the format of usbmon captures
+	linkTypeUSBLinuxMmapped = 220
+	usbmonHeaderSize        = 64
+)
+
+// PcapngWriter is a Tap which writes reports into a pcapng stream as
+// interrupt transfers of a usbmon capture, so they can be opened in Wireshark
+// next to captures of a real Pro Controller.
+type PcapngWriter struct {
+	w      io.Writer
+	mu     sync.Mutex
+	id     uint64
+	Bus    uint16
+	Device uint8
+	err    error
+}
+
+// NewPcapngWriter writes the pcapng section and interface headers to w
+// and returns a writer for the packets
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	p := &PcapngWriter{w: w, Bus: 1, Device: 1}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], 0x1a2b3c4d) // Byte-order magic
+	binary.LittleEndian.PutUint16(shb[4:], 1)          // Major version
+	binary.LittleEndian.PutUint16(shb[6:], 0)          // Minor version
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0)) // Section length unknown
+	if err := p.writeBlock(pcapngBlockSHB, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], linkTypeUSBLinuxMmapped)
+	binary.LittleEndian.PutUint32(idb[4:], 0) // No snapshot length limit
+	if err := p.writeBlock(pcapngBlockIDB, idb); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Report implements Tap
+func (p *PcapngWriter) Report(dir Direction, t time.Time, data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return
+	}
+	p.id++
+
+	hdr := make([]byte, usbmonHeaderSize)
+	binary.LittleEndian.PutUint64(hdr[0:], p.id)
+	if dir == DirIn {
+		hdr[8] = 'C'   // Completion carries IN data
+		hdr[10] = 0x81 // Interrupt IN endpoint
+	} else {
+		hdr[8] = 'S'   // Submission carries OUT data
+		hdr[10] = 0x01 // Interrupt OUT endpoint
+	}
+	hdr[9] = 1 // Interrupt transfer
+	hdr[11] = p.Device
+	binary.LittleEndian.PutUint16(hdr[12:], p.Bus)
+	hdr[14] = '-' // No setup packet
+	hdr[15] = 0   // Data present
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(t.Unix()))
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[32:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[36:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[48:], 8) // bInterval
+
+	packet := append(hdr, data...)
+
+	ts := uint64(t.UnixMicro())
+	epb := make([]byte, 20, 20+len(packet)+3)
+	binary.LittleEndian.PutUint32(epb[0:], 0) // Interface ID
+	binary.LittleEndian.PutUint32(epb[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(packet)))
+	epb = append(epb, packet...)
+
+	p.err = p.writeBlock(pcapngBlockEPB, epb)
+}
+
+// Err returns the first write error, after which reports are discarded
+func (p *PcapngWriter) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *PcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	padded := (len(body) + 3) &^ 3
+	total := uint32(12 + padded)
+
+	block := make([]byte, total)
+	binary.LittleEndian.PutUint32(block[0:], blockType)
+	binary.LittleEndian.PutUint32(block[4:], total)
+	copy(block[8:], body)
+	binary.LittleEndian.PutUint32(block[total-4:], total)
+
+	_, err := p.w.Write(block)
+	return err
+}
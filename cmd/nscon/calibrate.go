@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lmLumos/nscon/evdev"
+	"github.com/lmLumos/nscon/mapping"
+)
+
+// calibrationSticks are the axes of the sticks calibrated when present
+var calibrationSticks = [][2]uint16{
+	{evdev.ABS_X, evdev.ABS_Y},
+	{evdev.ABS_RX, evdev.ABS_RY},
+}
+
+// sampleInterval is the interval at which the stick positions are recorded
+const sampleInterval = 5 * time.Millisecond
+
+func runCalibrate(args []string) int {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	file := fs.String("file", mapping.DefaultCalibrationPath, "file storing the calibrations")
+	rest := fs.Duration("rest", time.Second, "time to measure the sticks at rest")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nscon calibrate [options] /dev/input/eventX")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := calibrate(fs.Arg(0), *file, *rest); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func calibrate(path, file string, rest time.Duration) error {
+	calibrations, err := mapping.LoadCalibrations(file)
+	if err != nil {
+		return err
+	}
+	device, err := evdev.Open(path)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	name, err := device.Name()
+	if err != nil {
+		return err
+	}
+	identity, err := device.Identity()
+	if err != nil {
+		return err
+	}
+	infos, err := device.AbsInfos()
+	if err != nil {
+		return err
+	}
+	var sticks [][2]uint16
+	for _, stick := range calibrationSticks {
+		if _, ok := infos[stick[0]]; !ok {
+			continue
+		}
+		if _, ok := infos[stick[1]]; ok {
+			sticks = append(sticks, stick)
+		}
+	}
+	if len(sticks) == 0 {
+		return fmt.Errorf("%s: no stick", path)
+	}
+	fmt.Printf("Calibrating %s (%s)\n", name, identity)
+
+	// The reader keeps the latest value of each axis for the sampler
+	var mu sync.Mutex
+	values := make(map[uint16]int32, len(infos))
+	for code, info := range infos {
+		values[code] = info.Value
+	}
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			e, err := device.ReadEvent()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if e.Type == evdev.EV_ABS {
+				mu.Lock()
+				values[e.Code] = e.Value
+				mu.Unlock()
+			}
+		}
+	}()
+	record := func(samples [][][2]int32) {
+		mu.Lock()
+		defer mu.Unlock()
+		for i, stick := range sticks {
+			samples[i] = append(samples[i], [2]int32{values[stick[0]], values[stick[1]]})
+		}
+	}
+	// sample records the sticks until done is closed
+	sample := func(done <-chan struct{}) ([][][2]int32, error) {
+		samples := make([][][2]int32, len(sticks))
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				record(samples)
+			case err := <-errc:
+				return nil, fmt.Errorf("%s: %w", path, err)
+			case <-done:
+				return samples, nil
+			}
+		}
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	enter := func() <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			stdin.ReadString('\n')
+			close(done)
+		}()
+		return done
+	}
+
+	fmt.Print("Let go of the sticks and press Enter.")
+	<-enter()
+	timer := make(chan struct{})
+	time.AfterFunc(rest, func() { close(timer) })
+	atRest, err := sample(timer)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("Rotate each stick slowly around its edge a few times, then press Enter.")
+	atEdge, err := sample(enter())
+	if err != nil {
+		return err
+	}
+
+	var cal mapping.Calibration
+	for i, stick := range sticks {
+		x, y := evdev.CodeName(evdev.EV_ABS, stick[0]), evdev.CodeName(evdev.EV_ABS, stick[1])
+		sc, err := mapping.NewStickCalibration(x, y, atRest[i], atEdge[i])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s/%s: center %d/%d, range %d..%d/%d..%d, roundness %.2f..%.2f, noise %.3f\n",
+			x, y, sc.Center[0], sc.Center[1], sc.Min[0], sc.Max[0], sc.Min[1], sc.Max[1],
+			slices.Min(sc.Radius), slices.Max(sc.Radius), sc.Noise)
+		cal.Sticks = append(cal.Sticks, sc)
+	}
+	if err := calibrations.Set(identity, cal); err != nil {
+		return err
+	}
+	fmt.Printf("Saved to %s\n", file)
+	return nil
+}
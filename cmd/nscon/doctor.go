@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lmLumos/nscon/gadget"
+	"github.com/lmLumos/nscon/hid"
+)
+
+type severity int
+
+const (
+	sevOK severity = iota
+	sevInfo
+	sevWarn
+	sevFail
+)
+
+func (s severity) String() string {
+	return [...]string{" OK ", "INFO", "WARN", "FAIL"}[s]
+}
+
+// doctor collects the findings of the checks
+type doctor struct {
+	fs     gadget.Configfs
+	failed bool
+}
+
+func (d *doctor) report(sev severity, msg string, hints ...string) {
+	fmt.Printf("[%s] %s\n", sev, msg)
+	for _, hint := range hints {
+		fmt.Printf("       -> %s\n", hint)
+	}
+	if sev == sevFail {
+		d.failed = true
+	}
+}
+
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	listen := fs.Duration("listen", 5*time.Second, "time to wait for the host handshake on each hidg device, 0 to skip")
+	fs.Parse(args)
+
+	d := &doctor{fs: gadget.System}
+	d.checkModules()
+	if !d.checkConfigfs() {
+		return 1
+	}
+	udcs := d.checkUDCs()
+	devices := d.checkGadgets(udcs)
+	for _, device := range devices {
+		if d.checkDevice(device) && *listen > 0 {
+			d.checkHandshake(device, *listen)
+		}
+	}
+
+	if d.failed {
+		return 1
+	}
+	return 0
+}
+
+// loadedModules returns the modules listed in /proc/modules
+func loadedModules() map[string]bool {
+	modules := map[string]bool{}
+	f, err := os.Open("/proc/modules")
+	if err != nil {
+		return modules
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, _, _ := strings.Cut(scanner.Text(), " ")
+		modules[name] = true
+	}
+	return modules
+}
+
+// moduleAvailable reports whether a module is loaded or built into the kernel
+func moduleAvailable(loaded map[string]bool, name string) bool {
+	if loaded[name] {
+		return true
+	}
+	_, err := os.Stat("/sys/module/" + name)
+	return err == nil
+}
+
+func (d *doctor) checkModules() {
+	loaded := loadedModules()
+	for _, m := range []struct{ name, why string }{
+		{"libcomposite", "USB gadget configfs support"},
+		{"usb_f_hid", "HID gadget function"},
+	} {
+		if moduleAvailable(loaded, m.name) {
+			d.report(sevOK, fmt.Sprintf("module %s loaded (%s)", m.name, m.why))
+		} else {
+			d.report(sevWarn, fmt.Sprintf("module %s not loaded (%s)", m.name, m.why),
+				"sudo modprobe "+m.name)
+		}
+	}
+
+	var udcDrivers []string
+	for _, name := range []string{"dwc2", "dwc3", "dummy_hcd"} {
+		if moduleAvailable(loaded, name) {
+			udcDrivers = append(udcDrivers, name)
+		}
+	}
+	if len(udcDrivers) > 0 {
+		d.report(sevOK, "UDC driver loaded: "+strings.Join(udcDrivers, ", "))
+	} else {
+		d.report(sevWarn, "no known UDC driver (dwc2, dwc3, dummy_hcd) loaded",
+			"on a Raspberry Pi add dtoverlay=dwc2 to /boot/config.txt and dwc2 to /etc/modules, then reboot",
+			"to test without hardware: sudo modprobe dummy_hcd")
+	}
+}
+
+func (d *doctor) checkConfigfs() bool {
+	if _, err := os.Stat(d.fs.Root); err != nil {
+		d.report(sevFail, fmt.Sprintf("%s not available: %v", d.fs.Root, err),
+			"sudo mount -t configfs none /sys/kernel/config",
+			"sudo modprobe libcomposite")
+		return false
+	}
+	d.report(sevOK, "configfs available at "+d.fs.Root)
+	return true
+}
+
+func (d *doctor) checkUDCs() map[string]bool {
+	udcs, err := d.fs.UDCs()
+	if err != nil || len(udcs) == 0 {
+		d.report(sevFail, "no USB device controller in "+d.fs.UDCRoot,
+			"the board must support USB device mode on its OTG port, e.g. dtoverlay=dwc2")
+		return nil
+	}
+	available := map[string]bool{}
+	for _, udc := range udcs {
+		state, _ := d.fs.UDCState(udc)
+		d.report(sevOK, fmt.Sprintf("UDC %s (state: %s)", udc, state))
+		available[udc] = true
+	}
+	return available
+}
+
+// checkGadgets inspects the gadgets and returns the device nodes of the bound ones
+func (d *doctor) checkGadgets(udcs map[string]bool) []string {
+	gadgets, err := d.fs.List()
+	if err != nil {
+		d.report(sevFail, fmt.Sprintf("cannot list gadgets: %v", err))
+		return nil
+	}
+	if len(gadgets) == 0 {
+		d.report(sevFail, "no USB gadget configured", "sudo nscon gadget create")
+		return nil
+	}
+
+	var devices []string
+	for _, g := range gadgets {
+		vendor, _ := g.Attr("idVendor")
+		product, _ := g.Attr("idProduct")
+		if vendor != "0x057e" || product != "0x2009" {
+			d.report(sevInfo, fmt.Sprintf("gadget %s is %s:%s, not a Pro Controller", g.Name, vendor, product))
+		}
+
+		functions, _ := g.Functions()
+		if len(functions) == 0 {
+			d.report(sevWarn, fmt.Sprintf("gadget %s has no HID function", g.Name))
+			continue
+		}
+		for _, function := range functions {
+			d.checkDescriptor(g, function)
+		}
+
+		udc, _ := g.UDC()
+		if udc == "" {
+			d.report(sevFail, fmt.Sprintf("gadget %s is not bound to a UDC", g.Name),
+				"echo <udc> | sudo tee "+d.fs.Root+"/"+g.Name+"/UDC")
+			continue
+		}
+		if !udcs[udc] {
+			d.report(sevFail, fmt.Sprintf("gadget %s is bound to missing UDC %s", g.Name, udc))
+			continue
+		}
+
+		switch state, _ := d.fs.UDCState(udc); state {
+		case "configured":
+			d.report(sevOK, fmt.Sprintf("gadget %s bound to %s and configured by the host", g.Name, udc))
+		case "not attached":
+			d.report(sevWarn, fmt.Sprintf("gadget %s bound to %s, but no host is attached", g.Name, udc),
+				"connect the OTG port to the Switch dock or console with a data cable",
+				"on a Raspberry Pi Zero use the USB port, not PWR IN")
+		case "suspended":
+			d.report(sevInfo, fmt.Sprintf("gadget %s bound to %s, the host is suspended", g.Name, udc))
+		default:
+			d.report(sevWarn, fmt.Sprintf("gadget %s bound to %s, the host has not configured it (state: %s)", g.Name, udc, state),
+				"check that \"Pro Controller Wired Communication\" is enabled in the Switch settings")
+		}
+
+		gadgetDevices, err := g.Devices()
+		if err != nil {
+			d.report(sevFail, err.Error())
+			continue
+		}
+		devices = append(devices, gadgetDevices...)
+	}
+	return devices
+}
+
+func (d *doctor) checkDescriptor(g *gadget.Gadget, function string) {
+	desc, err := g.ReportDescriptor(function)
+	if err != nil {
+		d.report(sevFail, err.Error())
+		return
+	}
+	if _, err := hid.Parse(desc); err != nil {
+		d.report(sevFail, fmt.Sprintf("gadget %s %s: invalid report descriptor: %v", g.Name, function, err),
+			"recreate the gadget with nscon gadget create")
+		return
+	}
+	if !bytes.Equal(desc, hid.ProController.Bytes()) {
+		d.report(sevInfo, fmt.Sprintf("gadget %s %s does not use the Pro Controller report descriptor", g.Name, function))
+	}
+}
+
+func (d *doctor) checkDevice(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		d.report(sevFail, fmt.Sprintf("%s missing: %v", path, err),
+			"check udev rules and dmesg for errors of the HID function")
+		return false
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		d.report(sevFail, path+" is not a character device")
+		return false
+	}
+	if err := syscall.Access(path, 0x2|0x4); err != nil { // W_OK | R_OK
+		d.report(sevFail, fmt.Sprintf("%s not accessible: %v (mode %s)", path, err, info.Mode().Perm()),
+			"sudo chmod 666 "+path+", or run as root")
+		return false
+	}
+	d.report(sevOK, fmt.Sprintf("%s accessible (mode %s)", path, info.Mode().Perm()))
+	return true
+}
+
+// checkHandshake waits for the 0x80 0x01 request the Switch sends after enumeration
+func (d *doctor) checkHandshake(path string, timeout time.Duration) {
+	fmt.Printf("       waiting %s for the host handshake on %s, replug the cable now...\n", timeout, path)
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		d.report(sevFail, err.Error())
+		return
+	}
+	defer f.Close()
+
+	deadline := time.Now().Add(timeout)
+	f.SetReadDeadline(deadline)
+	buf := make([]byte, 64)
+	for time.Now().Before(deadline) {
+		n, err := f.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		} else if err != nil {
+			d.report(sevFail, fmt.Sprintf("reading %s: %v", path, err))
+			return
+		}
+		if n >= 2 && buf[0] == 0x80 && buf[1] == 0x01 {
+			d.report(sevOK, "host sent the 0x80 0x01 handshake on "+path)
+			return
+		}
+	}
+	d.report(sevWarn, "no handshake from the host on "+path,
+		"stop other programs using the device, as only one of them receives the reports",
+		"enable \"Pro Controller Wired Communication\" in System Settings > Controllers and Sensors")
+}
@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lmLumos/nscon/gadget"
+	"github.com/lmLumos/nscon/hid"
+)
+
+func runGadget(args []string) int {
+	if len(args) < 1 {
+		gadgetUsage()
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		return gadgetCreate(args[1:])
+	case "remove":
+		return gadgetRemove(args[1:])
+	case "list":
+		return gadgetList()
+	case "descriptor":
+		return gadgetDescriptor(args[1:])
+	}
+	gadgetUsage()
+	return 2
+}
+
+func gadgetUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: nscon gadget create [options]")
+	fmt.Fprintln(os.Stderr, "       nscon gadget remove [pattern...]")
+	fmt.Fprintln(os.Stderr, "       nscon gadget list")
+	fmt.Fprintln(os.Stderr, "       nscon gadget descriptor [-profile name]")
+}
+
+func gadgetCreate(args []string) int {
+	fs := flag.NewFlagSet("gadget create", flag.ExitOnError)
+	name := fs.String("name", "procon", "gadget name")
+	functions := fs.Int("functions", 1, "number of HID functions in the gadget")
+	udc := fs.String("udc", "", "UDC to bind to, the first one when empty; with -per-udc a comma-separated list, all when empty")
+	bind := fs.Bool("bind", true, "bind the gadget after creating it")
+	mode := fs.Uint("mode", 0666, "permissions of the /dev/hidgN nodes")
+	profile := fs.String("profile", "procon", "report descriptor profile: procon, joycon or retro")
+	perUDC := fs.Bool("per-udc", false, "create one gadget per UDC, named after -name with an index")
+	fs.Parse(args)
+
+	if *perUDC && !*bind {
+		fmt.Fprintln(os.Stderr, "-per-udc always binds the gadgets, drop -bind=false")
+		return 2
+	}
+
+	desc, err := profileDescriptor(*profile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	cfg := gadget.ProController(*name)
+	cfg.Functions = *functions
+	cfg.MaxPower = 500 * *functions
+	cfg.ReportDescriptor = desc.Bytes()
+	cfg.ReportLength = desc.ReportLength()
+
+	if *perUDC {
+		var udcs []string
+		if *udc != "" {
+			udcs = strings.Split(*udc, ",")
+		}
+		return gadgetCreatePerUDC(cfg, udcs, os.FileMode(*mode))
+	}
+
+	g, err := gadget.System.Create(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("Created gadget", g.Name)
+	if !*bind {
+		return 0
+	}
+
+	if err := g.Bind(*udc); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	bound, _ := g.UDC()
+	fmt.Println("Bound to", bound)
+
+	devices, err := g.Devices()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, device := range devices {
+		if err := waitChmod(device, os.FileMode(*mode), 3*time.Second); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		fmt.Println("Ready:", device)
+	}
+	return status
+}
+
+func gadgetCreatePerUDC(cfg gadget.Config, udcs []string, mode os.FileMode) int {
+	bindings, err := gadget.System.CreatePerUDC(cfg, udcs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	status := 0
+	for i, b := range bindings {
+		devices, err := b.Gadget.Devices()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		for _, device := range devices {
+			if err := waitChmod(device, mode, 3*time.Second); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 1
+			}
+		}
+		fmt.Printf("Controller %d: %s on %s -> %s\n", i+1, b.Gadget.Name, b.UDC, strings.Join(devices, " "))
+	}
+	return status
+}
+
+// waitChmod waits for udev to create the device node and sets its permissions
+func waitChmod(path string, mode os.FileMode, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := os.Chmod(path, mode)
+		if err == nil || !os.IsNotExist(err) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func gadgetRemove(patterns []string) int {
+	if len(patterns) == 0 {
+		patterns = []string{"procon*"}
+	}
+
+	gadgets, err := gadget.System.List()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	status := 0
+	for _, g := range gadgets {
+		if !matchAny(patterns, g.Name) {
+			continue
+		}
+		if err := g.Remove(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		fmt.Println("Removed gadget", g.Name)
+	}
+	return status
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func gadgetList() int {
+	gadgets, err := gadget.System.List()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, g := range gadgets {
+		udc, _ := g.UDC()
+		if udc == "" {
+			udc = "(unbound)"
+		}
+		devices, _ := g.Devices()
+		fmt.Printf("%-16s %-20s %s\n", g.Name, udc, strings.Join(devices, " "))
+	}
+	return 0
+}
+
+func profileDescriptor(name string) (*hid.Descriptor, error) {
+	switch name {
+	case "procon":
+		return hid.ProController, nil
+	case "joycon":
+		return hid.JoyCon.Descriptor()
+	case "retro":
+		return hid.Retro.Descriptor()
+	}
+	return nil, fmt.Errorf("unknown profile %q", name)
+}
+
+// gadgetDescriptor prints a report descriptor as hex, as the setup scripts expect
+func gadgetDescriptor(args []string) int {
+	fs := flag.NewFlagSet("gadget descriptor", flag.ExitOnError)
+	profile := fs.String("profile", "procon", "report descriptor profile: procon, joycon or retro")
+	verbose := fs.Bool("v", false, "print the items and reports instead")
+	fs.Parse(args)
+
+	desc, err := profileDescriptor(*profile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if !*verbose {
+		fmt.Printf("%X\n", desc.Bytes())
+		return 0
+	}
+	for _, item := range desc.Items {
+		fmt.Println(item)
+	}
+	for _, r := range desc.Reports {
+		fmt.Printf("report 0x%02x %-7s %d bytes\n", r.ID, r.Kind, r.Size())
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"calibrate", "record the center and range of the sticks of a gamepad", runCalibrate},
+	{"doctor", "diagnose why a controller does not show up", runDoctor},
+	{"gadget", "create, list and remove Pro Controller USB gadgets", runGadget},
+	{"replay", "replay a capture of a Switch against the emulator", runReplay},
+	{"selftest", "test the emulator against the kernel over dummy_hcd", runSelftest},
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: nscon <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+
+	printUsage()
+	os.Exit(2)
+}
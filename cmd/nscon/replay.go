@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/replay"
+)
+
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 0, "time to wait for the replies to one host report")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: nscon replay [options] capture.{pcap,pcapng,jsonl}")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	packets, err := replay.Load(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	con := nscon.NewController("replay")
+	mismatches, err := replay.Run(con, packets, replay.Options{Timeout: *timeout})
+	for _, m := range mismatches {
+		fmt.Println(m)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(mismatches) > 0 {
+		fmt.Printf("%d mismatch(es) in %d reports\n", len(mismatches), len(packets))
+		return 1
+	}
+	fmt.Printf("OK: %d reports\n", len(packets))
+	return 0
+}
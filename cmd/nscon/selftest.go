@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/lmLumos/nscon/hosttest"
+)
+
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	name := fs.String("name", "nscon_test", "name of the temporary gadget")
+	timeout := fs.Duration("timeout", 5*time.Second, "time limit of each step")
+	keep := fs.Bool("keep-hid-nintendo", false, "don't unload hid_nintendo while testing")
+	debug := fs.Bool("debug", false, "log every report")
+	fs.Parse(args)
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	err := hosttest.Run(hosttest.Options{
+		Gadget:             *name,
+		Timeout:            *timeout,
+		KeepNintendoDriver: *keep,
+		Logger:             slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "FAIL:", err)
+		return 1
+	}
+	fmt.Println("OK")
+	return 0
+}
@@ -3,84 +3,93 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/kbm"
+	"github.com/lmLumos/nscon/mapping"
 	"log"
+	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
-	"time"
+	"strings"
+	"syscall"
 )
 
-func setInput(input *uint8) {
-	*input++
-	time.AfterFunc(100*time.Millisecond, func() {
-		*input--
-	})
+func printUsage() {
+	fmt.Println("Usage: sudo go run demo/main.go [options] [/dev/input/eventX ...]")
+	fmt.Println("Plays the Pro Controller with the keyboards and mice, or the given devices.")
+	fmt.Println("Options:")
+	fmt.Println("  --grab          Take the keyboards and mice exclusively, Ctrl+Alt+Esc quits")
+	fmt.Println("  --mapping=FILE  Use the first profile of FILE instead of the default")
+	fmt.Println("  --gyro          Aim with the mouse through the gyro, middle button recenters")
+	fmt.Println("  --log-level=L   Log at level L: debug, info (default), warn or error")
+	fmt.Println("  -v              Log at debug level, including every report")
+	fmt.Println("Default keys: WASD left stick, mouse right stick, mouse buttons ZR/ZL,")
+	fmt.Println("arrows d-pad, Enter A, Space B, . X, / Y, Q L, E R, Tab ZL, \\ ZR,")
+	fmt.Println("G Plus, F Minus, H Home, ` Capture, Shift and middle button stick presses")
 }
 
 func main() {
-	target := "/dev/hidg0"
-	con := nscon.NewController(target)
-	con.LogLevel = 2
-	defer con.Close()
-	con.Connect()
-
-	buf := make([]byte, 1)
-
-	// Set tty break for read keyboard input directly
-	exec.Command("stty", "-F", "/dev/tty", "cbreak", "min", "1").Run()
-	defer exec.Command("stty", "-F", "/dev/tty", "-cbreak").Run()
-	exec.Command("stty", "-F", "/dev/tty", "-echo").Run()
-	defer exec.Command("stty", "-F", "/dev/tty", "echo").Run()
-
-	go func() {
-		for {
-			os.Stdin.Read(buf)
-			switch buf[0] {
-			case 'a':
-				setInput(&con.Input.Dpad.Left)
-			case 'd':
-				setInput(&con.Input.Dpad.Right)
-			case 'w':
-				setInput(&con.Input.Dpad.Up)
-			case 's':
-				setInput(&con.Input.Dpad.Down)
-			case ' ':
-				setInput(&con.Input.Button.B)
-			case 0x0a: // Enter
-				setInput(&con.Input.Button.A)
-			case '.':
-				setInput(&con.Input.Button.X)
-			case '/':
-				setInput(&con.Input.Button.Y)
-			case 0x1b: // Escape
-				setInput(&con.Input.Button.Home)
-			case '`':
-				setInput(&con.Input.Button.Capture)
-			case '	':
-				setInput(&con.Input.Button.ZL)
-			case 'q':
-				setInput(&con.Input.Button.L)
-			case ']':
-				setInput(&con.Input.Button.R)
-			case '\\':
-				setInput(&con.Input.Button.ZL)
-			case 'g':
-				setInput(&con.Input.Button.Plus)
-			case 'f':
-				setInput(&con.Input.Button.Minus)
-			default:
-				log.Printf("unknown: %c = 0x%02x\n", buf[0], buf[0])
+	src := &kbm.Source{}
+	gyro := false
+	level := slog.LevelInfo
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "-h" || arg == "--help":
+			printUsage()
+			return
+		case arg == "--grab":
+			src.Grab = true
+		case arg == "--gyro":
+			gyro = true
+		case arg == "-v":
+			level = slog.LevelDebug
+		case strings.HasPrefix(arg, "--log-level="):
+			if err := level.UnmarshalText([]byte(strings.TrimPrefix(arg, "--log-level="))); err != nil {
+				log.Fatalf("Invalid log level: %v", err)
+			}
+		case strings.HasPrefix(arg, "--mapping="):
+			profiles, err := mapping.LoadFile(strings.TrimPrefix(arg, "--mapping="))
+			if err != nil {
+				log.Fatalf("Failed to load mapping profiles: %v", err)
 			}
+			if len(profiles) == 0 {
+				log.Fatalf("No profile in %s", arg)
+			}
+			src.Profile = profiles[0]
+		default:
+			src.Paths = append(src.Paths, arg)
 		}
-	}()
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	}
+	if gyro {
+		profile := *mapping.KeyboardMouse
+		if src.Profile != nil {
+			profile = *src.Profile
+		}
+		profile.Mouse = &mapping.Mouse{Target: "Gyro", Sensitivity: 2, Recenter: "BTN_MIDDLE"}
+		src.Profile = &profile
+	}
+	if len(src.Paths) == 0 {
+		var err error
+		if src.Paths, err = kbm.Find(); err != nil {
+			log.Fatalf("Failed to find keyboards and mice: %v", err)
+		}
+	}
+	log.Printf("Reading keyboards and mice: %v", src.Paths)
 
-	select {
-	case <-c:
-		return
+	target := "/dev/hidg0"
+	con := nscon.NewController(target)
+	con.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	con.Reconnect = nscon.DefaultReconnectPolicy
+	defer con.Close()
+	if err := con.Connect(); err != nil {
+		log.Fatalf("Failed to connect: %v", err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := src.Run(ctx, con); err != nil {
+		log.Printf("Input stopped: %v", err)
+	}
 }
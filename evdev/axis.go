@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package evdev
+
+// Normalize maps value from [Minimum, Maximum] onto [-1, 1], reading values
+// within Flat of the center as 0. The range outside the flat is stretched so
+// that the full stick travel is still reachable.
+func (a AbsInfo) Normalize(value int32) float64 {
+	return a.normalize(value, float64(a.Flat))
+}
+
+// NormalizeWithoutFlat maps value from [Minimum, Maximum] onto [-1, 1] like
+// Normalize but without the flat, for sticks applying a deadzone of their own
+func (a AbsInfo) NormalizeWithoutFlat(value int32) float64 {
+	return a.normalize(value, 0)
+}
+
+// FlatFraction returns Flat as a fraction of the distance from the center to
+// the edges, at most 0.5 like in Normalize, for the deadzone of a stick
+func (a AbsInfo) FlatFraction() float64 {
+	if a.Maximum <= a.Minimum {
+		return 0
+	}
+	half := (float64(a.Maximum) - float64(a.Minimum)) / 2
+	return min(float64(a.Flat), half/2) / half
+}
+
+func (a AbsInfo) normalize(value int32, flat float64) float64 {
+	if a.Maximum <= a.Minimum {
+		return 0
+	}
+	center := (float64(a.Minimum) + float64(a.Maximum)) / 2
+	half := (float64(a.Maximum) - float64(a.Minimum)) / 2
+	dz := min(flat, half/2)
+
+	d := float64(value) - center
+	switch {
+	case d > dz:
+		return min((d-dz)/(half-dz), 1)
+	case d < -dz:
+		return max((d+dz)/(half-dz), -1)
+	}
+	return 0
+}
+
+// NormalizeUnsigned maps value from [Minimum, Maximum] onto [0, 1], for axes
+// resting at their minimum like analog triggers. Values within Flat of the
+// minimum read as 0.
+func (a AbsInfo) NormalizeUnsigned(value int32) float64 {
+	if a.Maximum <= a.Minimum {
+		return 0
+	}
+	span := float64(a.Maximum) - float64(a.Minimum)
+	dz := min(float64(a.Flat), span/2)
+
+	d := float64(value) - float64(a.Minimum) - dz
+	return min(max(d/(span-dz), 0), 1)
+}
+
+// AbsInfos queries the ranges of all absolute axes of the device
+func (d *Device) AbsInfos() (map[uint16]AbsInfo, error) {
+	codes, err := d.bits(EV_ABS, ABS_MAX)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[uint16]AbsInfo, len(codes))
+	for _, code := range codes {
+		info, err := d.AbsInfo(code)
+		if err != nil {
+			return nil, err
+		}
+		infos[code] = info
+	}
+	return infos, nil
+}
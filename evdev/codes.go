@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package evdev
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Event types, from linux/input-event-codes.h
+const (
+	EV_SYN = 0x00
+	EV_KEY = 0x01
+	EV_REL = 0x02
+	EV_ABS = 0x03
+	EV_MSC = 0x04
+	EV_SW  = 0x05
+	EV_LED = 0x11
+	EV_SND = 0x12
+	EV_REP = 0x14
+	EV_FF  = 0x15
+	EV_MAX = 0x1f
+)
+
+// Synchronization events
+const (
+	SYN_REPORT  = 0
+	SYN_DROPPED = 3
+)
+
+// Joystick and gamepad button ranges
+const (
+	BTN_MISC          = 0x100
+	BTN_JOYSTICK      = 0x120
+	BTN_GAMEPAD       = 0x130
+	BTN_DIGI          = 0x140
+	BTN_TRIGGER_HAPPY = 0x2c0
+)
+
+// Gamepad buttons
+const (
+	BTN_SOUTH  = 0x130
+	BTN_EAST   = 0x131
+	BTN_C      = 0x132
+	BTN_NORTH  = 0x133
+	BTN_WEST   = 0x134
+	BTN_Z      = 0x135
+	BTN_TL     = 0x136
+	BTN_TR     = 0x137
+	BTN_TL2    = 0x138
+	BTN_TR2    = 0x139
+	BTN_SELECT = 0x13a
+	BTN_START  = 0x13b
+	BTN_MODE   = 0x13c
+	BTN_THUMBL = 0x13d
+	BTN_THUMBR = 0x13e
+
+	BTN_A = BTN_SOUTH
+	BTN_B = BTN_EAST
+	BTN_X = BTN_NORTH
+	BTN_Y = BTN_WEST
+
+	BTN_DPAD_UP    = 0x220
+	BTN_DPAD_DOWN  = 0x221
+	BTN_DPAD_LEFT  = 0x222
+	BTN_DPAD_RIGHT = 0x223
+)
+
+// Mouse buttons
+const (
+	BTN_LEFT   = 0x110
+	BTN_RIGHT  = 0x111
+	BTN_MIDDLE = 0x112
+	BTN_SIDE   = 0x113
+	BTN_EXTRA  = 0x114
+)
+
+// KEY_MAX is the highest key code
+const KEY_MAX = 0x2ff
+
+// Relative axes
+const (
+	REL_X      = 0x00
+	REL_Y      = 0x01
+	REL_HWHEEL = 0x06
+	REL_WHEEL  = 0x08
+	REL_MAX    = 0x0f
+)
+
+// Absolute axes
+const (
+	ABS_X        = 0x00
+	ABS_Y        = 0x01
+	ABS_Z        = 0x02
+	ABS_RX       = 0x03
+	ABS_RY       = 0x04
+	ABS_RZ       = 0x05
+	ABS_THROTTLE = 0x06
+	ABS_RUDDER   = 0x07
+	ABS_WHEEL    = 0x08
+	ABS_GAS      = 0x09
+	ABS_BRAKE    = 0x0a
+	ABS_HAT0X    = 0x10
+	ABS_HAT0Y    = 0x11
+	ABS_MISC     = 0x28
+	ABS_MAX      = 0x3f
+)
+
+// Miscellaneous events
+const (
+	MSC_SCAN      = 0x04
+	MSC_TIMESTAMP = 0x05
+	MSC_MAX       = 0x07
+)
+
+// Maximum codes of the remaining event types
+const (
+	SW_MAX  = 0x10
+	LED_MAX = 0x0f
+	SND_MAX = 0x07
+	FF_MAX  = 0x7f
+)
+
+// Device properties
+const (
+	INPUT_PROP_POINTER       = 0x00
+	INPUT_PROP_DIRECT        = 0x01
+	INPUT_PROP_ACCELEROMETER = 0x06
+	INPUT_PROP_MAX           = 0x1f
+)
+
+// Bus types
+const (
+	BUS_USB       = 0x03
+	BUS_BLUETOOTH = 0x05
+	BUS_VIRTUAL   = 0x06
+)
+
+// maxCode is the highest code of each event type with codes to enumerate
+var maxCode = map[uint16]uint16{
+	EV_KEY: KEY_MAX,
+	EV_REL: REL_MAX,
+	EV_ABS: ABS_MAX,
+	EV_MSC: MSC_MAX,
+	EV_SW:  SW_MAX,
+	EV_LED: LED_MAX,
+	EV_SND: SND_MAX,
+	EV_REP: 0x01,
+	EV_FF:  FF_MAX,
+}
+
+type typeCode struct{ typ, code uint16 }
+
+// codeNames maps the names of the codes above to their type and code
+var codeNames = map[string]typeCode{
+	"BTN_SOUTH": {EV_KEY, BTN_SOUTH}, "BTN_EAST": {EV_KEY, BTN_EAST}, "BTN_C": {EV_KEY, BTN_C},
+	"BTN_NORTH": {EV_KEY, BTN_NORTH}, "BTN_WEST": {EV_KEY, BTN_WEST}, "BTN_Z": {EV_KEY, BTN_Z},
+	"BTN_TL": {EV_KEY, BTN_TL}, "BTN_TR": {EV_KEY, BTN_TR}, "BTN_TL2": {EV_KEY, BTN_TL2}, "BTN_TR2": {EV_KEY, BTN_TR2},
+	"BTN_SELECT": {EV_KEY, BTN_SELECT}, "BTN_START": {EV_KEY, BTN_START}, "BTN_MODE": {EV_KEY, BTN_MODE},
+	"BTN_THUMBL": {EV_KEY, BTN_THUMBL}, "BTN_THUMBR": {EV_KEY, BTN_THUMBR},
+	"BTN_A": {EV_KEY, BTN_A}, "BTN_B": {EV_KEY, BTN_B}, "BTN_X": {EV_KEY, BTN_X}, "BTN_Y": {EV_KEY, BTN_Y},
+	"BTN_DPAD_UP": {EV_KEY, BTN_DPAD_UP}, "BTN_DPAD_DOWN": {EV_KEY, BTN_DPAD_DOWN},
+	"BTN_DPAD_LEFT": {EV_KEY, BTN_DPAD_LEFT}, "BTN_DPAD_RIGHT": {EV_KEY, BTN_DPAD_RIGHT},
+	"BTN_LEFT": {EV_KEY, BTN_LEFT}, "BTN_RIGHT": {EV_KEY, BTN_RIGHT}, "BTN_MIDDLE": {EV_KEY, BTN_MIDDLE},
+	"BTN_SIDE": {EV_KEY, BTN_SIDE}, "BTN_EXTRA": {EV_KEY, BTN_EXTRA},
+	"REL_X": {EV_REL, REL_X}, "REL_Y": {EV_REL, REL_Y}, "REL_HWHEEL": {EV_REL, REL_HWHEEL}, "REL_WHEEL": {EV_REL, REL_WHEEL},
+	"ABS_X": {EV_ABS, ABS_X}, "ABS_Y": {EV_ABS, ABS_Y}, "ABS_Z": {EV_ABS, ABS_Z},
+	"ABS_RX": {EV_ABS, ABS_RX}, "ABS_RY": {EV_ABS, ABS_RY}, "ABS_RZ": {EV_ABS, ABS_RZ},
+	"ABS_THROTTLE": {EV_ABS, ABS_THROTTLE}, "ABS_RUDDER": {EV_ABS, ABS_RUDDER}, "ABS_WHEEL": {EV_ABS, ABS_WHEEL},
+	"ABS_GAS": {EV_ABS, ABS_GAS}, "ABS_BRAKE": {EV_ABS, ABS_BRAKE},
+	"ABS_HAT0X": {EV_ABS, ABS_HAT0X}, "ABS_HAT0Y": {EV_ABS, ABS_HAT0Y}, "ABS_MISC": {EV_ABS, ABS_MISC},
+}
+
+// ParseCode returns the event type and code of a name like "BTN_SOUTH" or
+// "ABS_X", or of codes without a name written as type:code like "0x01:0x120"
+func ParseCode(name string) (typ, code uint16, err error) {
+	if tc, ok := codeNames[name]; ok {
+		return tc.typ, tc.code, nil
+	}
+	if t, c, ok := strings.Cut(name, ":"); ok {
+		t64, terr := strconv.ParseUint(t, 0, 16)
+		c64, cerr := strconv.ParseUint(c, 0, 16)
+		if terr == nil && cerr == nil {
+			return uint16(t64), uint16(c64), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unknown event code %q", name)
+}
+
+// CodeName returns the name of code of the event type typ, or its number if unknown
+func CodeName(typ, code uint16) string {
+	// Prefer the positional names over their aliases like BTN_A
+	name := ""
+	for n, tc := range codeNames {
+		if tc == (typeCode{typ, code}) && (name == "" || len(n) > len(name) || len(n) == len(name) && n < name) {
+			name = n
+		}
+	}
+	if name == "" {
+		return fmt.Sprintf("0x%02x:0x%03x", typ, code)
+	}
+	return name
+}
@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package evdev
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	iocWrite = 1
+	iocRead  = 2
+)
+
+// ioc returns the ioctl request number like the _IOC macro
+func ioc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'E'<<8 | nr
+}
+
+func eviocgname(size uintptr) uintptr    { return ioc(iocRead, 0x06, size) }
+func eviocgphys(size uintptr) uintptr    { return ioc(iocRead, 0x07, size) }
+func eviocguniq(size uintptr) uintptr    { return ioc(iocRead, 0x08, size) }
+func eviocgprop(size uintptr) uintptr    { return ioc(iocRead, 0x09, size) }
+func eviocgbit(ev, size uintptr) uintptr { return ioc(iocRead, 0x20+ev, size) }
+func eviocgabs(abs uintptr) uintptr      { return ioc(iocRead, 0x40+abs, unsafe.Sizeof(AbsInfo{})) }
+
+var (
+	eviocgid  = ioc(iocRead, 0x02, unsafe.Sizeof(ID{}))
+	eviocgrab = ioc(iocWrite, 0x90, unsafe.Sizeof(int32(0)))
+)
+
+// ID identifies the hardware of a device, as struct input_id
+type ID struct {
+	BusType uint16
+	Vendor  uint16
+	Product uint16
+	Version uint16
+}
+
+// AbsInfo is the range of an absolute axis, as struct input_absinfo
+type AbsInfo struct {
+	Value      int32
+	Minimum    int32
+	Maximum    int32
+	Fuzz       int32
+	Flat       int32
+	Resolution int32
+}
+
+// Device is an opened /dev/input/event* node
+type Device struct {
+	Path string
+	f    *os.File
+	dec  *Decoder
+}
+
+// Open opens the event device at path for reading
+func Open(path string) (*Device, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{Path: path, f: f, dec: NewDecoder(f)}, nil
+}
+
+// Close closes the device, which also ends a pending ReadEvent and releases a grab
+func (d *Device) Close() error {
+	return d.f.Close()
+}
+
+// ReadEvent blocks until the next event
+func (d *Device) ReadEvent() (Event, error) {
+	return d.dec.Decode()
+}
+
+// ioctl calls the ioctl req with a pointer argument
+func (d *Device) ioctl(req uintptr, arg unsafe.Pointer) error {
+	return d.control(req, func(fd uintptr) syscall.Errno {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+		return errno
+	})
+}
+
+// ioctlValue calls the ioctl req with an integer argument
+func (d *Device) ioctlValue(req, v uintptr) error {
+	return d.control(req, func(fd uintptr) syscall.Errno {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, v)
+		return errno
+	})
+}
+
+// control runs f on the file descriptor without switching it to blocking mode as Fd does
+func (d *Device) control(req uintptr, f func(fd uintptr) syscall.Errno) error {
+	conn, err := d.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) { errno = f(fd) }); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return fmt.Errorf("%s: ioctl 0x%08x: %w", d.Path, req, errno)
+	}
+	return nil
+}
+
+func (d *Device) ioctlString(req func(uintptr) uintptr) (string, error) {
+	buf := make([]byte, 256)
+	if err := d.ioctl(req(uintptr(len(buf))), unsafe.Pointer(&buf[0])); err != nil {
+		return "", err
+	}
+	name, _, _ := bytes.Cut(buf, []byte{0})
+	return string(name), nil
+}
+
+// Name returns the name of the device, e.g. "Wireless Controller"
+func (d *Device) Name() (string, error) {
+	return d.ioctlString(eviocgname)
+}
+
+// Phys returns the physical location of the device, e.g. its USB port
+func (d *Device) Phys() (string, error) {
+	return d.ioctlString(eviocgphys)
+}
+
+// Uniq returns the unique identifier of the device, e.g. the MAC address of
+// a Bluetooth controller. It is empty if the device has none.
+func (d *Device) Uniq() (string, error) {
+	uniq, err := d.ioctlString(eviocguniq)
+	if errors.Is(err, syscall.ENOENT) {
+		return "", nil
+	}
+	return uniq, err
+}
+
+// ID returns the bus, vendor, product and version of the device
+func (d *Device) ID() (ID, error) {
+	var id ID
+	err := d.ioctl(eviocgid, unsafe.Pointer(&id))
+	return id, err
+}
+
+// Identity returns a key that stays the same for the same physical device
+// across reconnects and reboots: the vendor and product with the unique
+// identifier, e.g. a Bluetooth MAC address, or the physical location if
+// there is none
+func (d *Device) Identity() (string, error) {
+	id, err := d.ID()
+	if err != nil {
+		return "", err
+	}
+	uniq, err := d.Uniq()
+	if err != nil {
+		return "", err
+	}
+	if uniq != "" {
+		return fmt.Sprintf("%04x:%04x/%s", id.Vendor, id.Product, strings.ToLower(uniq)), nil
+	}
+	phys, err := d.Phys()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%04x:%04x@%s", id.Vendor, id.Product, phys), nil
+}
+
+// AbsInfo returns the range of the absolute axis code
+func (d *Device) AbsInfo(code uint16) (AbsInfo, error) {
+	var info AbsInfo
+	err := d.ioctl(eviocgabs(uintptr(code)), unsafe.Pointer(&info))
+	return info, err
+}
+
+// Grab takes the device exclusively, so that no other program, including the
+// console and the X server, receives its events until Release or Close
+func (d *Device) Grab() error {
+	return d.ioctlValue(eviocgrab, 1)
+}
+
+// Release gives up a grab
+func (d *Device) Release() error {
+	return d.ioctlValue(eviocgrab, 0)
+}
+
+// GrabUntil closes the device once ctx is done, which unblocks a pending
+// ReadEvent on shutdown, and takes it exclusively until then if grab is
+// set. The kernel drops the grab when the device is closed, also when the
+// process exits or crashes, so it needs no Release. Calling stop before ctx
+// is done keeps the device open.
+func (d *Device) GrabUntil(ctx context.Context, grab bool) (stop func() bool, err error) {
+	if grab {
+		if err := d.Grab(); err != nil {
+			return nil, err
+		}
+	}
+	return context.AfterFunc(ctx, func() { d.Close() }), nil
+}
+
+// Capabilities maps the supported event types to their supported codes
+type Capabilities map[uint16][]uint16
+
+// Has reports whether the device supports code of the event type typ
+func (c Capabilities) Has(typ, code uint16) bool {
+	for _, c := range c[typ] {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsJoystick reports whether the capabilities are those of a joystick or
+// gamepad, like the ID_INPUT_JOYSTICK property of udev
+func (c Capabilities) IsJoystick() bool {
+	if len(c[EV_REL]) > 0 {
+		return false
+	}
+	for _, code := range c[EV_KEY] {
+		if code >= BTN_JOYSTICK && code < BTN_DIGI || code >= BTN_TRIGGER_HAPPY && code < BTN_TRIGGER_HAPPY+0x28 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKeyboard reports whether the capabilities are those of a keyboard, like
+// the ID_INPUT_KEYBOARD property of udev: it has the keys from Escape to D
+func (c Capabilities) IsKeyboard() bool {
+	for code := uint16(KEY_ESC); code <= KEY_D; code++ {
+		if !c.Has(EV_KEY, code) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsMouse reports whether the capabilities are those of a mouse: relative
+// X and Y axes and a left button
+func (c Capabilities) IsMouse() bool {
+	return c.Has(EV_REL, REL_X) && c.Has(EV_REL, REL_Y) && c.Has(EV_KEY, BTN_LEFT)
+}
+
+// Capabilities enumerates the event types and codes the device supports
+func (d *Device) Capabilities() (Capabilities, error) {
+	types, err := d.bits(0, EV_MAX)
+	if err != nil {
+		return nil, err
+	}
+	caps := Capabilities{}
+	for _, typ := range types {
+		max, ok := maxCode[typ]
+		if !ok {
+			caps[typ] = nil
+			continue
+		}
+		codes, err := d.bits(typ, max)
+		if err != nil {
+			return nil, err
+		}
+		caps[typ] = codes
+	}
+	return caps, nil
+}
+
+// Properties returns the INPUT_PROP_* properties of the device
+func (d *Device) Properties() ([]uint16, error) {
+	buf := make([]byte, INPUT_PROP_MAX/8+1)
+	if err := d.ioctl(eviocgprop(uintptr(len(buf))), unsafe.Pointer(&buf[0])); err != nil {
+		return nil, err
+	}
+	return setBits(buf), nil
+}
+
+// bits queries the bitmask of the codes of typ, or of the types for typ 0
+func (d *Device) bits(typ, max uint16) ([]uint16, error) {
+	buf := make([]byte, max/8+1)
+	if err := d.ioctl(eviocgbit(uintptr(typ), uintptr(len(buf))), unsafe.Pointer(&buf[0])); err != nil {
+		return nil, err
+	}
+	return setBits(buf), nil
+}
+
+func setBits(buf []byte) []uint16 {
+	var set []uint16
+	for i, b := range buf {
+		for bit := 0; bit < 8; bit++ {
+			if b&(1<<bit) != 0 {
+				set = append(set, uint16(i*8+bit))
+			}
+		}
+	}
+	return set
+}
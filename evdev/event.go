@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package evdev reads Linux input devices.
+package evdev
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// EventSize is the size of struct input_event on this architecture: 24 bytes
+// on 64-bit systems, 16 bytes on 32-bit ones like ARMv7
+const EventSize = int(unsafe.Sizeof(syscall.Timeval{})) + 8
+
+// Event is a decoded struct input_event
+type Event struct {
+	Time  time.Time
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("type 0x%02x code 0x%03x value %d", e.Type, e.Code, e.Value)
+}
+
+// Append appends the event encoded as a struct input_event of size bytes,
+// 16 or 24, to b
+func (e Event) Append(b []byte, size int) []byte {
+	sec, usec := e.Time.Unix(), int64(e.Time.Nanosecond()/1000)
+	if size == 16 {
+		b = binary.NativeEndian.AppendUint32(b, uint32(sec))
+		b = binary.NativeEndian.AppendUint32(b, uint32(usec))
+	} else {
+		b = binary.NativeEndian.AppendUint64(b, uint64(sec))
+		b = binary.NativeEndian.AppendUint64(b, uint64(usec))
+	}
+	b = binary.NativeEndian.AppendUint16(b, e.Type)
+	b = binary.NativeEndian.AppendUint16(b, e.Code)
+	return binary.NativeEndian.AppendUint32(b, uint32(e.Value))
+}
+
+// Decoder reads events from a device or a recorded event stream
+type Decoder struct {
+	r io.Reader
+	// Size is the size of one event, EventSize unless the stream was
+	// recorded on another architecture
+	Size int
+
+	buf        []byte
+	start, end int
+}
+
+// NewDecoder creates an instance of Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, Size: EventSize}
+}
+
+// Decode returns the next event. Reads from a device return whole events, so
+// up to 64 of them are read at once and returned one by one.
+func (d *Decoder) Decode() (Event, error) {
+	if d.Size != 16 && d.Size != 24 {
+		return Event{}, fmt.Errorf("invalid event size %d", d.Size)
+	}
+	if d.buf == nil {
+		d.buf = make([]byte, 64*d.Size)
+	}
+
+	for d.end-d.start < d.Size {
+		if d.start > 0 {
+			d.end = copy(d.buf, d.buf[d.start:d.end])
+			d.start = 0
+		}
+		n, err := d.r.Read(d.buf[d.end:])
+		d.end += n
+		if err == io.EOF && d.end > 0 && d.end < d.Size {
+			return Event{}, io.ErrUnexpectedEOF
+		} else if err != nil && d.end < d.Size {
+			return Event{}, err
+		}
+	}
+
+	b := d.buf[d.start : d.start+d.Size]
+	d.start += d.Size
+	return decode(b), nil
+}
+
+func decode(b []byte) Event {
+	var sec, usec int64
+	if len(b) == 16 {
+		sec = int64(int32(binary.NativeEndian.Uint32(b[0:])))
+		usec = int64(int32(binary.NativeEndian.Uint32(b[4:])))
+	} else {
+		sec = int64(binary.NativeEndian.Uint64(b[0:]))
+		usec = int64(binary.NativeEndian.Uint64(b[8:]))
+	}
+	b = b[len(b)-8:]
+	return Event{
+		Time:  time.Unix(sec, usec*1000),
+		Type:  binary.NativeEndian.Uint16(b[0:]),
+		Code:  binary.NativeEndian.Uint16(b[2:]),
+		Value: int32(binary.NativeEndian.Uint32(b[4:])),
+	}
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package evdev
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// recordedEvents are the events in testdata/events-*.bin, a press of the
+// south button while the left stick moves, recorded on little-endian systems
+// with 64-bit and 32-bit time
+var recordedEvents = []Event{
+	{time.Unix(1700000000, 123456000), EV_KEY, BTN_SOUTH, 1},
+	{time.Unix(1700000000, 123456000), EV_ABS, ABS_X, -32768},
+	{time.Unix(1700000000, 123456000), EV_ABS, ABS_Y, 32767},
+	{time.Unix(1700000000, 123456000), EV_SYN, SYN_REPORT, 0},
+	{time.Unix(1700000000, 131456000), EV_KEY, BTN_SOUTH, 0},
+	{time.Unix(1700000000, 131456000), EV_SYN, SYN_REPORT, 0},
+}
+
+func decodeAll(d *Decoder) ([]Event, error) {
+	var events []Event
+	for {
+		e, err := d.Decode()
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+}
+
+func equalEvents(a, b []Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Time.Equal(b[i].Time) || a[i].Type != b[i].Type || a[i].Code != b[i].Code || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecodeRecorded(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the recordings are little-endian")
+	}
+	for _, size := range []int{16, 24} {
+		b, err := os.ReadFile(fmt.Sprintf("testdata/events-%d.bin", size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, r := range map[string]io.Reader{
+			"whole":    bytes.NewReader(b),
+			"one byte": iotest.OneByteReader(bytes.NewReader(b)),
+		} {
+			d := NewDecoder(r)
+			d.Size = size
+			events, err := decodeAll(d)
+			if err != nil {
+				t.Errorf("%d bytes, %s: %v", size, name, err)
+			}
+			if !equalEvents(events, recordedEvents) {
+				t.Errorf("%d bytes, %s: got %v, want %v", size, name, events, recordedEvents)
+			}
+		}
+
+		var encoded []byte
+		for _, e := range recordedEvents {
+			encoded = e.Append(encoded, size)
+		}
+		if !bytes.Equal(encoded, b) {
+			t.Errorf("%d bytes: encoding differs from the recording", size)
+		}
+
+		d := NewDecoder(bytes.NewReader(b[:len(b)-1]))
+		d.Size = size
+		if _, err := decodeAll(d); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%d bytes, truncated: %v, want ErrUnexpectedEOF", size, err)
+		}
+	}
+}
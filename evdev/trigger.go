@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package evdev
+
+import "fmt"
+
+// Default thresholds of Trigger, as normalized travel. Below a press
+// threshold of 0.4 the release threshold defaults to 3/4 of it.
+const (
+	DefaultTriggerPress   = 0.5
+	DefaultTriggerRelease = 0.3
+)
+
+// Trigger turns an analog trigger, e.g. ABS_Z or ABS_GAS, into a button.
+// The button is pressed once the travel reaches Press and released once it
+// falls below Release, so that noise around one threshold doesn't toggle it.
+type Trigger struct {
+	// Press and Release are in [0, 1] with Release below Press; zero
+	// selects the defaults
+	Press   float64
+	Release float64
+
+	pressed bool
+}
+
+// Validate checks that the thresholds are in range and leave a gap
+func (t Trigger) Validate() error {
+	press, release := t.thresholds()
+	if press <= 0 || press > 1 || release < 0 {
+		return fmt.Errorf("trigger thresholds must be in [0, 1]")
+	}
+	if release >= press {
+		return fmt.Errorf("trigger release %g must be below press %g", release, press)
+	}
+	return nil
+}
+
+// thresholds returns Press and Release with the defaults applied
+func (t Trigger) thresholds() (press, release float64) {
+	press, release = t.Press, t.Release
+	if press == 0 {
+		press = DefaultTriggerPress
+	}
+	if release == 0 {
+		release = min(DefaultTriggerRelease, press*0.75)
+	}
+	return press, release
+}
+
+// Update feeds the normalized travel v and returns whether the button is
+// pressed and whether that changed
+func (t *Trigger) Update(v float64) (pressed, changed bool) {
+	press, release := t.thresholds()
+	switch {
+	case !t.pressed && v >= press:
+		t.pressed = true
+		return true, true
+	case t.pressed && v < release:
+		t.pressed = false
+		return false, true
+	}
+	return t.pressed, false
+}
+
+// Pressed reports whether the button is pressed
+func (t *Trigger) Pressed() bool {
+	return t.pressed
+}
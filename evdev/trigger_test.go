@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package evdev
+
+import "testing"
+
+func TestTriggerUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger Trigger
+		travel  []float64
+		pressed []bool
+	}{
+		{
+			name:    "defaults",
+			travel:  []float64{0, 0.49, 0.5, 0.4, 0.3, 0.29, 0.3, 0.49, 1},
+			pressed: []bool{false, false, true, true, true, false, false, false, true},
+		},
+		{
+			name:    "noise around press",
+			trigger: Trigger{Press: 0.6, Release: 0.4},
+			travel:  []float64{0.59, 0.61, 0.59, 0.61, 0.41, 0.39, 0.41},
+			pressed: []bool{false, true, true, true, true, false, false},
+		},
+		{
+			name:    "low press defaults release to 3/4",
+			trigger: Trigger{Press: 0.2},
+			travel:  []float64{0.2, 0.16, 0.151, 0.149, 0.19},
+			pressed: []bool{true, true, true, false, false},
+		},
+		{
+			name:    "full travel",
+			trigger: Trigger{Press: 1, Release: 0.9},
+			travel:  []float64{0.99, 1, 0.95, 0.89},
+			pressed: []bool{false, true, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trigger := tt.trigger
+			before := false
+			for i, v := range tt.travel {
+				pressed, changed := trigger.Update(v)
+				if pressed != tt.pressed[i] || changed != (pressed != before) {
+					t.Errorf("Update(%g) = %v, %v after %v, want %v", v, pressed, changed, before, tt.pressed[i])
+				}
+				if trigger.Pressed() != pressed {
+					t.Errorf("Pressed() = %v after Update(%g) = %v", trigger.Pressed(), v, pressed)
+				}
+				before = pressed
+			}
+		})
+	}
+}
+
+func TestTriggerValidate(t *testing.T) {
+	tests := []struct {
+		trigger Trigger
+		valid   bool
+	}{
+		{Trigger{}, true},
+		{Trigger{Press: 0.8, Release: 0.1}, true},
+		{Trigger{Press: 1}, true},
+		{Trigger{Press: 0.1}, true},
+		{Trigger{Release: 0.2}, true},
+		{Trigger{Press: 1.1}, false},
+		{Trigger{Press: -0.5}, false},
+		{Trigger{Release: -0.1}, false},
+		{Trigger{Press: 0.5, Release: 0.5}, false},
+		{Trigger{Press: 0.4, Release: 0.6}, false},
+		{Trigger{Release: 0.7}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.trigger.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v: Validate() = %v, want valid %v", tt.trigger, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package gadget creates Nintendo Switch Pro Controller USB gadgets through configfs.
+package gadget
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lmLumos/nscon/hid"
+)
+
+// ProControllerReportDescriptor is the HID report descriptor of the Pro Controller
+var ProControllerReportDescriptor = hid.ProController.Bytes()
+
+// Config describes a gadget to create
+type Config struct {
+	Name string
+
+	VendorID  uint16
+	ProductID uint16
+	BcdDevice uint16
+	BcdUSB    uint16
+
+	SerialNumber  string
+	Manufacturer  string
+	Product       string
+	Configuration string
+	MaxPower      int
+	Attributes    uint8
+
+	// Functions is the number of HID functions, each one becomes a /dev/hidgN
+	Functions        int
+	ReportDescriptor []byte
+	// ReportLength is derived from ReportDescriptor when 0
+	ReportLength int
+}
+
+// ProController returns the configuration of a Pro Controller gadget named name
+func ProController(name string) Config {
+	return Config{
+		Name:             name,
+		VendorID:         0x057e, // Nintendo Co., Ltd.
+		ProductID:        0x2009, // Pro Controller
+		BcdDevice:        0x0200,
+		BcdUSB:           0x0200,
+		SerialNumber:     "000000000001",
+		Manufacturer:     "Nintendo Co., Ltd.",
+		Product:          "Pro Controller",
+		Configuration:    "Nintendo Switch Pro Controller",
+		MaxPower:         500,
+		Attributes:       0xa0,
+		Functions:        1,
+		ReportDescriptor: ProControllerReportDescriptor,
+		ReportLength:     hid.ProController.ReportLength(),
+	}
+}
+
+// Configfs is the location of the usb_gadget configfs tree and the UDC class
+// directory
+type Configfs struct {
+	Root    string
+	UDCRoot string
+	// FS accesses the trees, the file system of the kernel if nil
+	FS FS
+}
+
+// FS are the file operations on configfs and the UDC class directory, for
+// tests to stand in a fake tree. Configfs creates the attributes and default
+// groups of an item with its directory and removes them with it.
+type FS interface {
+	ReadDir(name string) ([]os.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	Stat(name string) (os.FileInfo, error)
+	Mkdir(name string) error
+	// Remove removes a symlink or an item directory
+	Remove(name string) error
+	Symlink(oldname, newname string) error
+}
+
+// osFS is the FS of the kernel
+type osFS struct{}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) WriteFile(name string, data []byte) error   { return os.WriteFile(name, data, 0644) }
+func (osFS) Stat(name string) (os.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Mkdir(name string) error                    { return os.Mkdir(name, 0755) }
+func (osFS) Remove(name string) error                   { return os.Remove(name) }
+func (osFS) Symlink(oldname, newname string) error      { return os.Symlink(oldname, newname) }
+
+func (fs Configfs) files() FS {
+	if fs.FS == nil {
+		return osFS{}
+	}
+	return fs.FS
+}
+
+// glob returns the entries of dir whose name matches pattern, in order
+func (fs Configfs) glob(dir, pattern string) ([]string, error) {
+	entries, err := fs.files().ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(pattern, entry.Name()); ok {
+			matches = append(matches, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return matches, nil
+}
+
+// System is the configfs of the running kernel
+var System = Configfs{
+	Root:    "/sys/kernel/config/usb_gadget",
+	UDCRoot: "/sys/class/udc",
+}
+
+// Gadget is a gadget directory in configfs
+type Gadget struct {
+	Name string
+	dir  string
+	fs   Configfs
+}
+
+const (
+	langDir   = "strings/0x409"
+	configDir = "configs/c.1"
+)
+
+// UDCs returns the names of the available USB device controllers
+func (fs Configfs) UDCs() ([]string, error) {
+	entries, err := fs.files().ReadDir(fs.UDCRoot)
+	if err != nil {
+		return nil, err
+	}
+	udcs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		udcs = append(udcs, entry.Name())
+	}
+	sort.Strings(udcs)
+	return udcs, nil
+}
+
+// UDCState returns the state attribute of udc, e.g. "configured" or "not attached"
+func (fs Configfs) UDCState(udc string) (string, error) {
+	state, err := fs.files().ReadFile(filepath.Join(fs.UDCRoot, udc, "state"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(state)), nil
+}
+
+// List returns the gadgets present in configfs
+func (fs Configfs) List() ([]*Gadget, error) {
+	entries, err := fs.files().ReadDir(fs.Root)
+	if err != nil {
+		return nil, err
+	}
+	var gadgets []*Gadget
+	for _, entry := range entries {
+		if entry.IsDir() {
+			gadgets = append(gadgets, fs.gadget(entry.Name()))
+		}
+	}
+	return gadgets, nil
+}
+
+// Open returns the existing gadget named name
+func (fs Configfs) Open(name string) (*Gadget, error) {
+	g := fs.gadget(name)
+	if _, err := fs.files().Stat(g.dir); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (fs Configfs) gadget(name string) *Gadget {
+	return &Gadget{Name: name, dir: filepath.Join(fs.Root, name), fs: fs}
+}
+
+// Create creates the gadget described by cfg. The gadget is not bound.
+func (fs Configfs) Create(cfg Config) (*Gadget, error) {
+	if cfg.Name == "" || strings.ContainsRune(cfg.Name, '/') {
+		return nil, fmt.Errorf("invalid gadget name %q", cfg.Name)
+	}
+	if cfg.Functions < 1 {
+		return nil, fmt.Errorf("gadget %s: at least one function is required", cfg.Name)
+	}
+	desc, err := hid.Parse(cfg.ReportDescriptor)
+	if err != nil {
+		return nil, fmt.Errorf("gadget %s: invalid report descriptor: %w", cfg.Name, err)
+	}
+	if len(desc.Reports) == 0 {
+		return nil, fmt.Errorf("gadget %s: report descriptor declares no report", cfg.Name)
+	}
+	if cfg.ReportLength == 0 {
+		cfg.ReportLength = desc.ReportLength()
+	}
+
+	g := fs.gadget(cfg.Name)
+	if _, err := fs.files().Stat(g.dir); err == nil {
+		return nil, fmt.Errorf("gadget %s: %w", cfg.Name, os.ErrExist)
+	}
+
+	if err := g.create(cfg); err != nil {
+		g.Remove()
+		return nil, fmt.Errorf("gadget %s: %w", cfg.Name, err)
+	}
+	return g, nil
+}
+
+func (g *Gadget) create(cfg Config) error {
+	// functions/, configs/ and strings/ are default groups of the gadget
+	files := g.fs.files()
+	for _, dir := range []string{"", langDir, configDir, filepath.Join(configDir, langDir)} {
+		if err := files.Mkdir(filepath.Join(g.dir, dir)); err != nil {
+			return err
+		}
+	}
+
+	attrs := []struct{ name, value string }{
+		{"idVendor", hex16(cfg.VendorID)},
+		{"idProduct", hex16(cfg.ProductID)},
+		{"bcdDevice", hex16(cfg.BcdDevice)},
+		{"bcdUSB", hex16(cfg.BcdUSB)},
+		{"bDeviceClass", "0x00"},
+		{"bDeviceSubClass", "0x00"},
+		{"bDeviceProtocol", "0x00"},
+		{filepath.Join(langDir, "serialnumber"), cfg.SerialNumber},
+		{filepath.Join(langDir, "manufacturer"), cfg.Manufacturer},
+		{filepath.Join(langDir, "product"), cfg.Product},
+		{filepath.Join(configDir, langDir, "configuration"), cfg.Configuration},
+		{filepath.Join(configDir, "MaxPower"), strconv.Itoa(cfg.MaxPower)},
+		{filepath.Join(configDir, "bmAttributes"), fmt.Sprintf("0x%02x", cfg.Attributes)},
+	}
+	for _, attr := range attrs {
+		if err := g.write(attr.name, attr.value+"\n"); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < cfg.Functions; i++ {
+		function := fmt.Sprintf("functions/hid.usb%d", i)
+		if err := files.Mkdir(filepath.Join(g.dir, function)); err != nil {
+			return err
+		}
+		attrs := []struct{ name, value string }{
+			{"protocol", "0\n"},
+			{"subclass", "0\n"},
+			{"report_length", strconv.Itoa(cfg.ReportLength) + "\n"},
+			{"report_desc", string(cfg.ReportDescriptor)},
+		}
+		for _, attr := range attrs {
+			if err := g.write(filepath.Join(function, attr.name), attr.value); err != nil {
+				return err
+			}
+		}
+		if err := files.Symlink(filepath.Join(g.dir, function),
+			filepath.Join(g.dir, configDir, filepath.Base(function))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bind binds the gadget to udc, or to the first available UDC when udc is empty
+func (g *Gadget) Bind(udc string) error {
+	if udc == "" {
+		udcs, err := g.fs.UDCs()
+		if err != nil {
+			return fmt.Errorf("gadget %s: %w", g.Name, err)
+		}
+		if len(udcs) == 0 {
+			return fmt.Errorf("gadget %s: no USB device controller found", g.Name)
+		}
+		udc = udcs[0]
+	}
+	if err := g.write("UDC", udc+"\n"); err != nil {
+		return fmt.Errorf("gadget %s: bind to %s: %w", g.Name, udc, err)
+	}
+	return nil
+}
+
+// Unbind detaches the gadget from its UDC
+func (g *Gadget) Unbind() error {
+	udc, err := g.UDC()
+	if err != nil || udc == "" {
+		return err
+	}
+	if err := g.write("UDC", "\n"); err != nil {
+		return fmt.Errorf("gadget %s: unbind: %w", g.Name, err)
+	}
+	return nil
+}
+
+// UDC returns the name of the UDC the gadget is bound to, or "" if unbound
+func (g *Gadget) UDC() (string, error) {
+	udc, err := g.fs.files().ReadFile(filepath.Join(g.dir, "UDC"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("gadget %s: %w", g.Name, err)
+	}
+	return strings.TrimSpace(string(udc)), nil
+}
+
+// Attr returns the value of the attribute name of the gadget, e.g. "idVendor"
+// or "functions/hid.usb0/report_length"
+func (g *Gadget) Attr(name string) (string, error) {
+	value, err := g.fs.files().ReadFile(filepath.Join(g.dir, name))
+	if err != nil {
+		return "", fmt.Errorf("gadget %s: %w", g.Name, err)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// ReportDescriptor returns the report descriptor of the HID function
+func (g *Gadget) ReportDescriptor(function string) ([]byte, error) {
+	desc, err := g.fs.files().ReadFile(filepath.Join(g.dir, "functions", function, "report_desc"))
+	if err != nil {
+		return nil, fmt.Errorf("gadget %s: %w", g.Name, err)
+	}
+	return desc, nil
+}
+
+// Functions returns the directory names of the HID functions of the gadget
+func (g *Gadget) Functions() ([]string, error) {
+	matches, err := g.fs.glob(filepath.Join(g.dir, "functions"), "hid.*")
+	if err != nil {
+		return nil, fmt.Errorf("gadget %s: %w", g.Name, err)
+	}
+	functions := make([]string, len(matches))
+	for i, match := range matches {
+		functions[i] = filepath.Base(match)
+	}
+	sort.Strings(functions)
+	return functions, nil
+}
+
+// Devices returns the /dev/hidgN paths of the HID functions of a bound gadget,
+// derived from the minor numbers in their dev attribute
+func (g *Gadget) Devices() ([]string, error) {
+	functions, err := g.Functions()
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, function := range functions {
+		dev, err := g.fs.files().ReadFile(filepath.Join(g.dir, "functions", function, "dev"))
+		if err != nil {
+			return nil, fmt.Errorf("gadget %s: %w", g.Name, err)
+		}
+		_, minor, ok := strings.Cut(strings.TrimSpace(string(dev)), ":")
+		if !ok {
+			return nil, fmt.Errorf("gadget %s: invalid dev attribute %q of %s", g.Name, dev, function)
+		}
+		devices = append(devices, "/dev/hidg"+minor)
+	}
+	return devices, nil
+}
+
+// Remove unbinds the gadget and tears down its configfs tree
+func (g *Gadget) Remove() error {
+	if err := g.Unbind(); err != nil {
+		return err
+	}
+
+	files := g.fs.files()
+	config := filepath.Join(g.dir, configDir)
+	links, _ := g.fs.glob(config, "hid.*")
+	for _, link := range links {
+		if err := files.Remove(link); err != nil {
+			return fmt.Errorf("gadget %s: %w", g.Name, err)
+		}
+	}
+
+	functions, _ := g.fs.glob(filepath.Join(g.dir, "functions"), "*")
+	dirs := append([]string{
+		filepath.Join(config, langDir),
+		config,
+	}, functions...)
+	dirs = append(dirs,
+		filepath.Join(g.dir, langDir),
+		g.dir,
+	)
+	for _, dir := range dirs {
+		if err := files.Remove(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("gadget %s: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+func (g *Gadget) write(name, value string) error {
+	return g.fs.files().WriteFile(filepath.Join(g.dir, name), []byte(value))
+}
+
+func hex16(v uint16) string {
+	return fmt.Sprintf("0x%04x", v)
+}
@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package gadget
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+// fakeConfigfs mimics configfs on a plain directory tree: creating an item
+// creates its attributes and default groups, writing an attribute that
+// doesn't exist fails, and an item is only removed once its children are
+type fakeConfigfs struct {
+	root  string
+	minor int
+}
+
+// attributes of the items, by the pattern of their path below a gadget
+var fakeItems = []struct {
+	pattern string
+	attrs   []string
+	groups  []string
+}{
+	{"*", []string{"UDC", "idVendor", "idProduct", "bcdDevice", "bcdUSB",
+		"bDeviceClass", "bDeviceSubClass", "bDeviceProtocol"}, []string{"configs", "functions", "strings"}},
+	{"*/strings/*", []string{"serialnumber", "manufacturer", "product"}, nil},
+	{"*/configs/*", []string{"MaxPower", "bmAttributes"}, []string{"strings"}},
+	{"*/configs/*/strings/*", []string{"configuration"}, nil},
+	{"*/functions/hid.*", []string{"protocol", "subclass", "report_length", "report_desc", "dev"}, nil},
+}
+
+func newFakeConfigfs(t *testing.T, udcs ...string) Configfs {
+	t.Helper()
+	dir := t.TempDir()
+	fs := Configfs{
+		Root:    filepath.Join(dir, "usb_gadget"),
+		UDCRoot: filepath.Join(dir, "udc"),
+	}
+	fs.FS = &fakeConfigfs{root: fs.Root}
+	for _, d := range []string{fs.Root, fs.UDCRoot} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, udc := range udcs {
+		if err := os.Mkdir(filepath.Join(fs.UDCRoot, udc), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(fs.UDCRoot, udc, "state"), []byte("not attached\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func (f *fakeConfigfs) ReadDir(name string) ([]os.DirEntry, error) { return os.ReadDir(name) }
+func (f *fakeConfigfs) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (f *fakeConfigfs) Stat(name string) (os.FileInfo, error)      { return os.Stat(name) }
+func (f *fakeConfigfs) Symlink(oldname, newname string) error      { return os.Symlink(oldname, newname) }
+
+func (f *fakeConfigfs) WriteFile(name string, data []byte) error {
+	if _, err := os.Stat(name); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+func (f *fakeConfigfs) Mkdir(name string) error {
+	if err := os.Mkdir(name, 0755); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(f.root, name)
+	if err != nil {
+		return err
+	}
+	for _, item := range fakeItems {
+		if ok, _ := filepath.Match(item.pattern, rel); !ok {
+			continue
+		}
+		for _, attr := range item.attrs {
+			value := ""
+			if attr == "dev" {
+				value = fmt.Sprintf("236:%d\n", f.minor)
+				f.minor++
+			}
+			if err := os.WriteFile(filepath.Join(name, attr), []byte(value), 0644); err != nil {
+				return err
+			}
+		}
+		for _, group := range item.groups {
+			if err := os.Mkdir(filepath.Join(name, group), 0755); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeConfigfs) Remove(name string) error {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return os.Remove(name)
+	}
+	if hasChildren(name, true) {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	return os.RemoveAll(name)
+}
+
+// hasChildren reports whether dir holds items or links. The directories in
+// an item are its default groups, which are removed with it when empty.
+func hasChildren(dir string, item bool) bool {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		switch {
+		case entry.Type()&os.ModeSymlink != 0:
+			return true
+		case entry.IsDir() && (!item || hasChildren(filepath.Join(dir, entry.Name()), false)):
+			return true
+		}
+	}
+	return false
+}
+
+func TestCreate(t *testing.T) {
+	fs := newFakeConfigfs(t, "dummy_udc.0")
+	g, err := fs.Create(ProController("procon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"idVendor":                                "0x057e",
+		"idProduct":                               "0x2009",
+		"strings/0x409/product":                   "Pro Controller",
+		"configs/c.1/MaxPower":                    "500",
+		"functions/hid.usb0/report_length":        "64",
+		"configs/c.1/strings/0x409/configuration": "Nintendo Switch Pro Controller",
+	} {
+		if got, err := g.Attr(name); err != nil || got != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	desc, err := g.ReportDescriptor("hid.usb0")
+	if err != nil || !bytes.Equal(desc, ProControllerReportDescriptor) {
+		t.Errorf("report descriptor % x, %v", desc, err)
+	}
+	if _, err := fs.Create(ProController("procon")); !errors.Is(err, os.ErrExist) {
+		t.Errorf("creating the gadget again: %v, want ErrExist", err)
+	}
+
+	if err := g.Bind(""); err != nil {
+		t.Fatal(err)
+	}
+	if udc, err := g.UDC(); err != nil || udc != "dummy_udc.0" {
+		t.Errorf("UDC() = %q, %v", udc, err)
+	}
+	if devices, err := g.Devices(); err != nil || !slices.Equal(devices, []string{"/dev/hidg0"}) {
+		t.Errorf("Devices() = %v, %v", devices, err)
+	}
+	if gadgets, err := fs.List(); err != nil || len(gadgets) != 1 || gadgets[0].Name != "procon" {
+		t.Errorf("List() = %v, %v", gadgets, err)
+	}
+
+	if err := g.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(fs.Root); err != nil || len(entries) != 0 {
+		t.Errorf("left behind %v, %v", entries, err)
+	}
+}
+
+func TestCreatePerUDC(t *testing.T) {
+	fs := newFakeConfigfs(t, "dummy_udc.0", "dummy_udc.1")
+	cfg := ProController("procon")
+	cfg.Functions = 2
+	bindings, err := fs.CreatePerUDC(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bindings) != 2 {
+		t.Fatalf("got %d bindings, want 2", len(bindings))
+	}
+	for i, b := range bindings {
+		if want := fmt.Sprintf("dummy_udc.%d", i); b.UDC != want {
+			t.Errorf("binding %d: UDC %s, want %s", i, b.UDC, want)
+		}
+		if want := fmt.Sprintf("/dev/hidg%d", 2*i); b.Device != want {
+			t.Errorf("binding %d: device %s, want %s", i, b.Device, want)
+		}
+		if b.Gadget.Name != fmt.Sprintf("procon%d", i) {
+			t.Errorf("binding %d: gadget %s", i, b.Gadget.Name)
+		}
+		if devices, err := b.Gadget.Devices(); err != nil || len(devices) != 2 {
+			t.Errorf("binding %d: devices %v, %v", i, devices, err)
+		}
+		if serial, _ := b.Gadget.Attr("strings/0x409/serialnumber"); serial != fmt.Sprintf("%012d", i+1) {
+			t.Errorf("binding %d: serial number %s", i, serial)
+		}
+		if err := b.Gadget.Remove(); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package gadget
+
+import (
+	"fmt"
+)
+
+// Binding is a gadget bound to its own UDC
+type Binding struct {
+	UDC    string
+	Gadget *Gadget
+	// Device is the first /dev/hidgN node of the gadget
+	Device string
+}
+
+// CreatePerUDC creates an independent gadget configured like cfg for each
+// UDC, named cfg.Name followed by its index, and binds it. When udcs is
+// empty all available UDCs are used, including the dummy_udc.N of
+// dummy_hcd. On error the gadgets created so far are removed.
+func (fs Configfs) CreatePerUDC(cfg Config, udcs []string) ([]Binding, error) {
+	if len(udcs) == 0 {
+		var err error
+		if udcs, err = fs.UDCs(); err != nil {
+			return nil, err
+		}
+		if len(udcs) == 0 {
+			return nil, fmt.Errorf("no USB device controller found")
+		}
+	}
+
+	var bindings []Binding
+	fail := func(err error) ([]Binding, error) {
+		for _, b := range bindings {
+			b.Gadget.Remove()
+		}
+		return nil, err
+	}
+
+	prefix := cfg.Name
+	for i, udc := range udcs {
+		cfg.Name = fmt.Sprintf("%s%d", prefix, i)
+		// The host tells controllers apart by their serial number
+		cfg.SerialNumber = fmt.Sprintf("%012d", i+1)
+
+		g, err := fs.Create(cfg)
+		if err != nil {
+			return fail(err)
+		}
+		bindings = append(bindings, Binding{UDC: udc, Gadget: g})
+		if err := g.Bind(udc); err != nil {
+			return fail(err)
+		}
+		devices, err := g.Devices()
+		if err != nil {
+			return fail(err)
+		}
+		bindings[i].Device = devices[0]
+	}
+	return bindings, nil
+}
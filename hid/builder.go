@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hid
+
+// Flags of Input, Output and Feature items
+const (
+	Constant = 1 << 0
+	Variable = 1 << 1
+	Relative = 1 << 2
+	Wrap     = 1 << 3
+	Volatile = 1 << 7
+)
+
+// Collection kinds
+const (
+	Physical    = 0x00
+	Application = 0x01
+	Logical     = 0x02
+)
+
+// Builder assembles the items of a report descriptor
+type Builder struct {
+	items []Item
+}
+
+// Item appends an item holding v
+func (b *Builder) Item(tag Tag, v int64) *Builder {
+	b.items = append(b.items, NewItem(tag, v))
+	return b
+}
+
+// UsagePage and the following methods append the item of the same name
+func (b *Builder) UsagePage(v uint16) *Builder      { return b.Item(TagUsagePage, int64(v)) }
+func (b *Builder) Usage(v uint32) *Builder          { return b.Item(TagUsage, int64(v)) }
+func (b *Builder) UsageMinimum(v uint32) *Builder   { return b.Item(TagUsageMinimum, int64(v)) }
+func (b *Builder) UsageMaximum(v uint32) *Builder   { return b.Item(TagUsageMaximum, int64(v)) }
+func (b *Builder) LogicalMinimum(v int32) *Builder  { return b.Item(TagLogicalMinimum, int64(v)) }
+func (b *Builder) LogicalMaximum(v int32) *Builder  { return b.Item(TagLogicalMaximum, int64(v)) }
+func (b *Builder) PhysicalMinimum(v int32) *Builder { return b.Item(TagPhysicalMinimum, int64(v)) }
+func (b *Builder) PhysicalMaximum(v int32) *Builder { return b.Item(TagPhysicalMaximum, int64(v)) }
+func (b *Builder) UnitExponent(v int8) *Builder     { return b.Item(TagUnitExponent, int64(v)) }
+func (b *Builder) Unit(v uint32) *Builder           { return b.Item(TagUnit, int64(v)) }
+func (b *Builder) ReportSize(bits uint8) *Builder   { return b.Item(TagReportSize, int64(bits)) }
+func (b *Builder) ReportCount(count uint8) *Builder { return b.Item(TagReportCount, int64(count)) }
+func (b *Builder) ReportID(id byte) *Builder        { return b.Item(TagReportID, int64(id)) }
+func (b *Builder) Input(flags uint8) *Builder       { return b.Item(TagInput, int64(flags)) }
+func (b *Builder) Output(flags uint8) *Builder      { return b.Item(TagOutput, int64(flags)) }
+func (b *Builder) Feature(flags uint8) *Builder     { return b.Item(TagFeature, int64(flags)) }
+func (b *Builder) Collection(kind uint8) *Builder   { return b.Item(TagCollection, int64(kind)) }
+func (b *Builder) EndCollection() *Builder {
+	b.items = append(b.items, Item{Tag: TagEndCollection})
+	return b
+}
+
+// Items returns the items appended so far
+func (b *Builder) Items() []Item {
+	return b.items
+}
+
+// Bytes returns the encoded descriptor
+func (b *Builder) Bytes() []byte {
+	return Encode(nil, b.items...)
+}
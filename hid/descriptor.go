@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hid
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Kind is the kind of main item a report is made of
+type Kind uint8
+
+const (
+	Input Kind = iota
+	Output
+	Feature
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Input:
+		return "input"
+	case Output:
+		return "output"
+	case Feature:
+		return "feature"
+	}
+	return "unknown"
+}
+
+// Report is a report declared by a descriptor
+type Report struct {
+	ID   byte
+	Kind Kind
+	// Bits is the size of the report data without the report ID
+	Bits int
+}
+
+// Size returns the size of the report data in bytes, without the report ID
+func (r Report) Size() int {
+	return (r.Bits + 7) / 8
+}
+
+// Descriptor is a parsed and validated report descriptor
+type Descriptor struct {
+	Items   []Item
+	Reports []Report
+}
+
+type globalState struct {
+	reportSize  int64
+	reportCount int64
+	reportID    int64
+	usagePage   bool
+}
+
+// Parse parses and validates an encoded report descriptor
+func Parse(b []byte) (*Descriptor, error) {
+	items, err := ParseItems(b)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(items)
+}
+
+// Validate checks the structure of items and computes the reports they declare
+func Validate(items []Item) (*Descriptor, error) {
+	var (
+		state       globalState
+		stack       []globalState
+		depth       int
+		usedIDs     bool
+		usedNoID    bool
+		usage       bool
+		reportBits  = map[[2]int]int{}
+		reportOrder [][2]int
+	)
+
+	for n, item := range items {
+		fail := func(format string, args ...interface{}) (*Descriptor, error) {
+			return nil, fmt.Errorf("item %d (%s): %s", n, item, fmt.Sprintf(format, args...))
+		}
+
+		if item.Tag.Type() == 3 {
+			return fail("reserved item type")
+		}
+
+		switch item.Tag {
+		case TagUsagePage:
+			state.usagePage = true
+		case TagReportSize:
+			state.reportSize = item.Value()
+		case TagReportCount:
+			state.reportCount = item.Value()
+		case TagReportID:
+			if item.Value() == 0 || item.Value() > 0xff {
+				return fail("report ID must be between 1 and 255")
+			}
+			state.reportID = item.Value()
+		case TagPush:
+			stack = append(stack, state)
+		case TagPop:
+			if len(stack) == 0 {
+				return fail("pop without push")
+			}
+			state = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+		case TagUsage, TagUsageMinimum, TagUsageMaximum:
+			// Extended usages carry their own usage page
+			if !state.usagePage && len(item.Data) < 4 {
+				return fail("usage without usage page")
+			}
+			usage = true
+
+		case TagCollection:
+			if !usage && depth == 0 {
+				return fail("application collection without usage")
+			}
+			depth++
+			usage = false
+		case TagEndCollection:
+			if depth == 0 {
+				return fail("end collection without collection")
+			}
+			depth--
+
+		case TagInput, TagOutput, TagFeature:
+			if depth == 0 {
+				return fail("main item outside of a collection")
+			}
+			if state.reportSize == 0 || state.reportCount == 0 {
+				return fail("report size and report count must be set")
+			}
+			if state.reportID != 0 {
+				usedIDs = true
+			} else {
+				usedNoID = true
+			}
+			if usedIDs && usedNoID {
+				return fail("report ID must be set on every report once used")
+			}
+
+			kind := Input
+			if item.Tag == TagOutput {
+				kind = Output
+			} else if item.Tag == TagFeature {
+				kind = Feature
+			}
+			key := [2]int{int(state.reportID), int(kind)}
+			if _, ok := reportBits[key]; !ok {
+				reportOrder = append(reportOrder, key)
+			}
+			reportBits[key] += int(state.reportSize * state.reportCount)
+			usage = false
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("%d collection(s) not closed", depth)
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("%d push(es) without pop", len(stack))
+	}
+
+	d := &Descriptor{Items: items}
+	for _, key := range reportOrder {
+		r := Report{ID: byte(key[0]), Kind: Kind(key[1]), Bits: reportBits[key]}
+		if r.Bits%8 != 0 {
+			return nil, fmt.Errorf("%s report 0x%02x is %d bits, not a whole number of bytes", r.Kind, r.ID, r.Bits)
+		}
+		d.Reports = append(d.Reports, r)
+	}
+	return d, nil
+}
+
+// Report returns the report with id and kind
+func (d *Descriptor) Report(id byte, kind Kind) (Report, bool) {
+	for _, r := range d.Reports {
+		if r.ID == id && r.Kind == kind {
+			return r, true
+		}
+	}
+	return Report{}, false
+}
+
+// IDs returns the report IDs of kind in ascending order
+func (d *Descriptor) IDs(kind Kind) []byte {
+	var ids []byte
+	for _, r := range d.Reports {
+		if r.Kind == kind {
+			ids = append(ids, r.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ReportLength returns the length of the longest report including its report ID,
+// which is the report_length of a HID gadget function
+func (d *Descriptor) ReportLength() int {
+	length := 0
+	for _, r := range d.Reports {
+		n := r.Size()
+		if r.ID != 0 {
+			n++
+		}
+		if n > length {
+			length = n
+		}
+	}
+	return length
+}
+
+// Bytes returns the encoded descriptor
+func (d *Descriptor) Bytes() []byte {
+	return Encode(nil, d.Items...)
+}
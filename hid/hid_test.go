@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hid
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// scriptDescriptor returns the report descriptor a setup script writes
+func scriptDescriptor(t *testing.T, script string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`HID_REPORT_DESC="([0-9A-Fa-f]+)"`).FindSubmatch(b)
+	if m == nil {
+		t.Fatalf("%s: no HID_REPORT_DESC", script)
+	}
+	desc, err := hex.DecodeString(string(m[1]))
+	if err != nil {
+		t.Fatalf("%s: %v", script, err)
+	}
+	return desc
+}
+
+func TestProControllerMatchesScripts(t *testing.T) {
+	for _, script := range []string{"../setup_multi_procon_gadgets.sh", "../setup_hub_emulation.sh"} {
+		if want := scriptDescriptor(t, script); !bytes.Equal(ProController.Bytes(), want) {
+			t.Errorf("%s:\n got % x\nwant % x", script, ProController.Bytes(), want)
+		}
+	}
+	if n := ProController.ReportLength(); n != 64 {
+		t.Errorf("report length %d, want 64", n)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := scriptDescriptor(t, "../setup_multi_procon_gadgets.sh")
+	items, err := ParseItems(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Encode(nil, items...); !bytes.Equal(got, want) {
+		t.Errorf("encoding the parsed items:\n got % x\nwant % x", got, want)
+	}
+
+	parsed, err := ParseItems(ProController.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(parsed, ProController.Items, func(a, b Item) bool {
+		return a.Tag == b.Tag && bytes.Equal(a.Data, b.Data)
+	}) {
+		t.Errorf("parsing the encoded items:\n got %v\nwant %v", parsed, ProController.Items)
+	}
+}
+
+func TestItemValues(t *testing.T) {
+	for _, tt := range []struct {
+		tag  Tag
+		v    int64
+		size int
+	}{
+		{TagLogicalMinimum, 0, 1},
+		{TagLogicalMinimum, -128, 1},
+		{TagLogicalMinimum, -129, 2},
+		{TagLogicalMaximum, 0x7fff, 2},
+		{TagLogicalMaximum, 0xffff, 4},
+		{TagUsage, 0xff, 1},
+		{TagUsage, 0x100, 2},
+		{TagUsage, 0x10030, 4},
+	} {
+		item := NewItem(tt.tag, tt.v)
+		if len(item.Data) != tt.size {
+			t.Errorf("%v %d: %d bytes, want %d", tt.tag, tt.v, len(item.Data), tt.size)
+		}
+		items, err := ParseItems(Encode(nil, item))
+		if err != nil || len(items) != 1 || items[0].Value() != tt.v {
+			t.Errorf("%v %d: parsed %v, %v", tt.tag, tt.v, items, err)
+		}
+	}
+
+	if _, err := ParseItems([]byte{0x26, 0xff}); err == nil {
+		t.Error("parsed a truncated item")
+	}
+}
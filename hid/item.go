@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package hid encodes, parses and validates HID report descriptors.
+package hid
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ItemType is the type of a short item
+type ItemType uint8
+
+const (
+	Main   ItemType = 0
+	Global ItemType = 1
+	Local  ItemType = 2
+)
+
+// Tag identifies a short item, combining its tag and type as in the prefix byte
+type Tag uint8
+
+// Main items
+const (
+	TagInput         Tag = 0x80
+	TagOutput        Tag = 0x90
+	TagFeature       Tag = 0xb0
+	TagCollection    Tag = 0xa0
+	TagEndCollection Tag = 0xc0
+)
+
+// Global items
+const (
+	TagUsagePage       Tag = 0x04
+	TagLogicalMinimum  Tag = 0x14
+	TagLogicalMaximum  Tag = 0x24
+	TagPhysicalMinimum Tag = 0x34
+	TagPhysicalMaximum Tag = 0x44
+	TagUnitExponent    Tag = 0x54
+	TagUnit            Tag = 0x64
+	TagReportSize      Tag = 0x74
+	TagReportID        Tag = 0x84
+	TagReportCount     Tag = 0x94
+	TagPush            Tag = 0xa4
+	TagPop             Tag = 0xb4
+)
+
+// Local items
+const (
+	TagUsage        Tag = 0x08
+	TagUsageMinimum Tag = 0x18
+	TagUsageMaximum Tag = 0x28
+	TagDesignator   Tag = 0x38
+	TagStringIndex  Tag = 0x78
+	TagDelimiter    Tag = 0xa8
+)
+
+// Type returns the item type encoded in the tag
+func (t Tag) Type() ItemType {
+	return ItemType(t>>2) & 0x03
+}
+
+func (t Tag) String() string {
+	if name, ok := tagNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Tag(0x%02x)", uint8(t))
+}
+
+var tagNames = map[Tag]string{
+	TagInput:           "Input",
+	TagOutput:          "Output",
+	TagFeature:         "Feature",
+	TagCollection:      "Collection",
+	TagEndCollection:   "End Collection",
+	TagUsagePage:       "Usage Page",
+	TagLogicalMinimum:  "Logical Minimum",
+	TagLogicalMaximum:  "Logical Maximum",
+	TagPhysicalMinimum: "Physical Minimum",
+	TagPhysicalMaximum: "Physical Maximum",
+	TagUnitExponent:    "Unit Exponent",
+	TagUnit:            "Unit",
+	TagReportSize:      "Report Size",
+	TagReportID:        "Report ID",
+	TagReportCount:     "Report Count",
+	TagPush:            "Push",
+	TagPop:             "Pop",
+	TagUsage:           "Usage",
+	TagUsageMinimum:    "Usage Minimum",
+	TagUsageMaximum:    "Usage Maximum",
+	TagDesignator:      "Designator Index",
+	TagStringIndex:     "String Index",
+	TagDelimiter:       "Delimiter",
+}
+
+// signed reports whether the data of the tag is a two's complement number
+func (t Tag) signed() bool {
+	switch t {
+	case TagLogicalMinimum, TagLogicalMaximum, TagPhysicalMinimum, TagPhysicalMaximum, TagUnitExponent:
+		return true
+	}
+	return false
+}
+
+// Item is a short item of a report descriptor
+type Item struct {
+	Tag  Tag
+	Data []byte
+}
+
+// Uint returns the data as an unsigned little-endian number
+func (i Item) Uint() uint32 {
+	var v uint32
+	for n, b := range i.Data {
+		v |= uint32(b) << (8 * n)
+	}
+	return v
+}
+
+// Int returns the data as a signed little-endian number
+func (i Item) Int() int32 {
+	switch len(i.Data) {
+	case 1:
+		return int32(int8(i.Data[0]))
+	case 2:
+		return int32(int16(i.Uint()))
+	}
+	return int32(i.Uint())
+}
+
+// Value returns the data as a number, signed or unsigned depending on the tag
+func (i Item) Value() int64 {
+	if i.Tag.signed() {
+		return int64(i.Int())
+	}
+	return int64(i.Uint())
+}
+
+func (i Item) String() string {
+	if len(i.Data) == 0 {
+		return i.Tag.String()
+	}
+	return fmt.Sprintf("%s (%d)", i.Tag, i.Value())
+}
+
+// NewItem returns an item holding v in the smallest data size, at least one byte
+func NewItem(tag Tag, v int64) Item {
+	size := 1
+	if tag.signed() {
+		switch {
+		case v < -0x8000 || v > 0x7fff:
+			size = 4
+		case v < -0x80 || v > 0x7f:
+			size = 2
+		}
+	} else {
+		switch {
+		case v > 0xffff:
+			size = 4
+		case v > 0xff:
+			size = 2
+		}
+	}
+
+	data := make([]byte, size)
+	for n := range data {
+		data[n] = byte(v >> (8 * n))
+	}
+	return Item{Tag: tag, Data: data}
+}
+
+// Encode appends the encoded items to b
+func Encode(b []byte, items ...Item) []byte {
+	for _, item := range items {
+		size := byte(len(item.Data))
+		if size == 4 {
+			size = 3
+		}
+		b = append(b, byte(item.Tag)|size)
+		b = append(b, item.Data...)
+	}
+	return b
+}
+
+// ErrLongItem is returned when parsing a descriptor containing long items
+var ErrLongItem = errors.New("long items are not supported")
+
+// ParseItems splits an encoded descriptor into its items
+func ParseItems(b []byte) ([]Item, error) {
+	var items []Item
+	for i := 0; i < len(b); {
+		prefix := b[i]
+		if prefix == 0xfe {
+			return nil, fmt.Errorf("offset %d: %w", i, ErrLongItem)
+		}
+		size := int(prefix & 0x03)
+		if size == 3 {
+			size = 4
+		}
+		if i+1+size > len(b) {
+			return nil, fmt.Errorf("offset %d: truncated item", i)
+		}
+		items = append(items, Item{
+			Tag:  Tag(prefix &^ 0x03),
+			Data: append([]byte(nil), b[i+1:i+1+size]...),
+		})
+		i += 1 + size
+	}
+	return items, nil
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hid
+
+import "fmt"
+
+// Usage pages and usages used by the profiles
+const (
+	UsagePageGenericDesktop = 0x01
+	UsagePageButton         = 0x09
+	UsagePageVendor         = 0xff00
+
+	UsageJoystick = 0x04
+	UsageGamepad  = 0x05
+	UsageX        = 0x30
+	UsageY        = 0x31
+	UsageZ        = 0x32
+	UsageRz       = 0x35
+	UsageHat      = 0x39
+)
+
+// Report IDs of the Nintendo Switch controller protocol
+const (
+	ReportStandardInput = 0x30 // Buttons, sticks and IMU
+	ReportSubcmdReply   = 0x21 // Reply to a UART subcommand
+	ReportUSBReply      = 0x81 // Reply to a USB command
+	ReportSubcmd        = 0x01 // Rumble and UART subcommand
+	ReportRumble        = 0x10 // Rumble only
+	ReportUSBCommand    = 0x80 // USB handshake command
+	ReportUSBPassthru   = 0x82 // UART passthrough without USB timeout
+)
+
+// extendedUsage combines a usage page and a usage into a 32-bit usage
+func extendedUsage(page, usage uint16) uint32 {
+	return uint32(page)<<16 | uint32(usage)
+}
+
+// vendorReports appends the vendor specific reports shared by the Switch
+// controllers, each 63 bytes long
+func vendorReports(b *Builder) *Builder {
+	b.UsagePage(UsagePageVendor)
+	for n, r := range []struct {
+		id     byte
+		output bool
+	}{
+		{ReportSubcmdReply, false},
+		{ReportUSBReply, false},
+		{ReportSubcmd, true},
+		{ReportRumble, true},
+		{ReportUSBCommand, true},
+		{ReportUSBPassthru, true},
+	} {
+		b.ReportID(r.id).Usage(uint32(n + 1)).ReportSize(8).ReportCount(63)
+		if r.output {
+			b.Output(Constant | Variable | Volatile)
+		} else {
+			b.Input(Constant | Variable)
+		}
+	}
+	return b
+}
+
+// ProController is the report descriptor of the Nintendo Switch Pro Controller
+var ProController = mustValidate(proController())
+
+func proController() []Item {
+	b := &Builder{}
+	b.UsagePage(UsagePageGenericDesktop).
+		LogicalMinimum(0).
+		Usage(UsageJoystick).
+		Collection(Application)
+
+	// Report 0x30: 14 buttons, 4 axes, hat and 4 more buttons
+	b.ReportID(ReportStandardInput).
+		UsagePage(UsagePageGenericDesktop).
+		UsagePage(UsagePageButton).
+		UsageMinimum(0x01).UsageMaximum(0x0a).
+		LogicalMinimum(0).LogicalMaximum(1).
+		ReportSize(1).ReportCount(10).
+		UnitExponent(0).Unit(0).
+		Input(Variable)
+	b.UsagePage(UsagePageButton).
+		UsageMinimum(0x0b).UsageMaximum(0x0e).
+		LogicalMinimum(0).LogicalMaximum(1).
+		ReportSize(1).ReportCount(4).
+		Input(Variable)
+	b.ReportSize(1).ReportCount(2).Input(Constant | Variable)
+
+	b.Usage(extendedUsage(UsagePageGenericDesktop, 0x01)).Collection(Physical)
+	for _, axis := range []uint16{UsageX, UsageY, UsageZ, UsageRz} {
+		b.Usage(extendedUsage(UsagePageGenericDesktop, axis))
+	}
+	b.LogicalMinimum(0).LogicalMaximum(0xffff).
+		ReportSize(16).ReportCount(4).
+		Input(Variable).
+		EndCollection()
+
+	b.Usage(extendedUsage(UsagePageGenericDesktop, UsageHat)).
+		LogicalMinimum(0).LogicalMaximum(7).
+		PhysicalMinimum(0).PhysicalMaximum(315).
+		Unit(0x14). // Degrees
+		ReportSize(4).ReportCount(1).
+		Input(Variable)
+	b.UsagePage(UsagePageButton).
+		UsageMinimum(0x0f).UsageMaximum(0x12).
+		LogicalMinimum(0).LogicalMaximum(1).
+		ReportSize(1).ReportCount(4).
+		Input(Variable)
+	b.ReportSize(8).ReportCount(52).Input(Constant | Variable)
+
+	vendorReports(b)
+	return b.EndCollection().Items()
+}
+
+// Gamepad describes a generic controller profile
+type Gamepad struct {
+	// Buttons is the number of buttons
+	Buttons int
+	// Sticks is the number of analog sticks, up to 2, each with an X and a Y axis of 16 bits
+	Sticks int
+	// Hat adds a 8-way hat switch for the D-pad
+	Hat bool
+	// Vendor adds the vendor reports of the Switch controller protocol and
+	// sends the input as report 0x30, as Joy-Con do
+	Vendor bool
+}
+
+// JoyCon is a single Joy-Con profile: one stick, 16 buttons and no hat
+var JoyCon = Gamepad{Buttons: 16, Sticks: 1, Vendor: true}
+
+// Retro is a retro pad profile: 12 buttons and a D-pad
+var Retro = Gamepad{Buttons: 12, Hat: true}
+
+// Descriptor builds the report descriptor of the profile
+func (g Gamepad) Descriptor() (*Descriptor, error) {
+	b := &Builder{}
+	b.UsagePage(UsagePageGenericDesktop).
+		Usage(UsageGamepad).
+		Collection(Application)
+	if g.Vendor {
+		b.ReportID(ReportStandardInput)
+	}
+
+	bits := 0
+	if g.Buttons > 0 {
+		b.UsagePage(UsagePageButton).
+			UsageMinimum(1).UsageMaximum(uint32(g.Buttons)).
+			LogicalMinimum(0).LogicalMaximum(1).
+			ReportSize(1).ReportCount(uint8(g.Buttons)).
+			Input(Variable)
+		bits += g.Buttons
+	}
+	if g.Hat {
+		b.UsagePage(UsagePageGenericDesktop).
+			Usage(UsageHat).
+			LogicalMinimum(0).LogicalMaximum(7).
+			PhysicalMinimum(0).PhysicalMaximum(315).
+			Unit(0x14).
+			ReportSize(4).ReportCount(1).
+			Input(Variable | 0x40) // Null state
+		b.Unit(0)
+		bits += 4
+	}
+	if pad := (8 - bits%8) % 8; pad > 0 {
+		b.ReportSize(uint8(pad)).ReportCount(1).Input(Constant)
+	}
+	if g.Sticks > 2 {
+		return nil, fmt.Errorf("%d sticks, at most 2 are supported", g.Sticks)
+	}
+	if g.Sticks > 0 {
+		axes := []uint16{UsageX, UsageY, UsageZ, UsageRz}
+		b.UsagePage(UsagePageGenericDesktop).Usage(0x01).Collection(Physical)
+		for i := 0; i < 2*g.Sticks; i++ {
+			b.Usage(uint32(axes[i]))
+		}
+		b.LogicalMinimum(0).LogicalMaximum(0xffff).
+			ReportSize(16).ReportCount(uint8(2 * g.Sticks)).
+			Input(Variable).
+			EndCollection()
+	}
+
+	if g.Vendor {
+		// The standard input report is always 63 bytes long
+		bits += 32 * g.Sticks
+		bits += (8 - bits%8) % 8
+		if pad := 63 - bits/8; pad > 0 {
+			b.ReportSize(8).ReportCount(uint8(pad)).Input(Constant | Variable)
+		}
+		vendorReports(b)
+	}
+	return Validate(b.EndCollection().Items())
+}
+
+func mustValidate(items []Item) *Descriptor {
+	d, err := Validate(items)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hosttest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/gadget"
+)
+
+// Options configures a harness run
+type Options struct {
+	// Gadget is the name of the temporary gadget
+	Gadget string
+	// Timeout bounds each step
+	Timeout time.Duration
+	// KeepNintendoDriver keeps hid_nintendo loaded. By default it is unloaded
+	// so that it doesn't run its own handshake against the emulated controller.
+	KeepNintendoDriver bool
+	Logger             *slog.Logger
+}
+
+// Run loads dummy_hcd, creates a Pro Controller gadget on it, connects a
+// Controller to the gadget side and plays the Switch on the host side.
+// It must run as root. The gadget is removed on return.
+func Run(opts Options) (err error) {
+	if opts.Gadget == "" {
+		opts.Gadget = "nscon_test"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+
+	step := func(name string) {
+		log.Info("step", "name", name)
+	}
+
+	step("load kernel modules")
+	for _, module := range []string{"libcomposite", "usb_f_hid", "dummy_hcd"} {
+		if err := modprobe(module); err != nil {
+			return err
+		}
+	}
+	if !opts.KeepNintendoDriver && hasModule("hid_nintendo") {
+		if err := modprobe("-r", "hid_nintendo"); err != nil {
+			return fmt.Errorf("hid_nintendo would answer the controller itself: %w", err)
+		}
+		defer modprobe("hid_nintendo")
+	}
+
+	step("create gadget")
+	udc, err := dummyUDC()
+	if err != nil {
+		return err
+	}
+	cfg := gadget.ProController(opts.Gadget)
+	g, err := gadget.System.Create(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rerr := g.Remove(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+	if err := g.Bind(udc); err != nil {
+		return err
+	}
+	devices, err := g.Devices()
+	if err != nil {
+		return err
+	}
+
+	step("connect controller")
+	con := nscon.NewController(devices[0])
+	con.Logger = log.With("side", "gadget")
+	if err := waitFor(opts.Timeout, func() error { return con.Connect() }); err != nil {
+		return err
+	}
+	defer con.Close()
+
+	step("open hidraw")
+	hidraw, err := FindHidraw(cfg.VendorID, cfg.ProductID, opts.Timeout)
+	if err != nil {
+		return err
+	}
+	host, err := Open(hidraw)
+	if err != nil {
+		return err
+	}
+	defer host.Close()
+	host.Timeout = opts.Timeout
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	step("handshake")
+	if reply, err := host.Command(0x01); err != nil {
+		return fmt.Errorf("status request: %w", err)
+	} else if reply[2] != 0x00 || reply[3] != 0x03 {
+		return fmt.Errorf("status reply % x: unexpected controller type", reply[:10])
+	}
+	if _, err := host.Command(0x02); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	if err := con.WaitState(ctx, nscon.Paired); err != nil {
+		return fmt.Errorf("controller not paired: %w", err)
+	}
+	if err := host.Send(0x80, 0x04); err != nil {
+		return err
+	}
+	if err := con.WaitState(ctx, nscon.Streaming); err != nil {
+		return fmt.Errorf("controller not streaming: %w", err)
+	}
+
+	step("device info")
+	reply, err := host.Subcommand(0x02)
+	if err != nil {
+		return fmt.Errorf("device info: %w", err)
+	}
+	if reply[13] != 0x82 {
+		return fmt.Errorf("device info: ack 0x%02x", reply[13])
+	}
+
+	step("read SPI")
+	reply, err = host.Subcommand(0x10, 0x00, 0x60, 0x00, 0x00, 0x10)
+	if err != nil {
+		return fmt.Errorf("SPI read: %w", err)
+	}
+	if want := nscon.SPI_ROM_DATA[0x60][:0x10]; !bytes.Equal(reply[20:20+0x10], want) {
+		return fmt.Errorf("SPI read: got % x, want % x", reply[20:20+0x10], want)
+	}
+
+	step("input report")
+	con.Input.Button.A = 1
+	deadline := time.Now().Add(opts.Timeout)
+	for {
+		report, err := host.Expect(0x30)
+		if err != nil {
+			return fmt.Errorf("input report: %w", err)
+		}
+		if report[3]&0x08 != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("input report: A button not pressed in % x", report[:13])
+		}
+	}
+
+	log.Info("all steps passed")
+	return nil
+}
+
+func modprobe(args ...string) error {
+	out, err := exec.Command("modprobe", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("modprobe %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// dummyUDC returns the first UDC of dummy_hcd
+func dummyUDC() (string, error) {
+	udcs, err := gadget.System.UDCs()
+	if err != nil {
+		return "", err
+	}
+	for _, udc := range udcs {
+		if strings.HasPrefix(udc, "dummy_udc") {
+			return udc, nil
+		}
+	}
+	return "", fmt.Errorf("no dummy_udc found among %v", udcs)
+}
+
+// waitFor retries f until it succeeds or timeout expires, as device nodes
+// appear asynchronously
+func waitFor(timeout time.Duration, f func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := f()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
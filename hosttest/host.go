@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package hosttest drives an emulated controller from the host side of a
+// dummy_hcd loopback, standing in for a Nintendo Switch.
+package hosttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FindHidraw waits until a hidraw node for the USB device vid:pid appears and returns its path
+func FindHidraw(vid, pid uint16, timeout time.Duration) (string, error) {
+	id := fmt.Sprintf("HID_ID=0003:%08X:%08X", vid, pid)
+	deadline := time.Now().Add(timeout)
+	for {
+		nodes, _ := filepath.Glob("/sys/class/hidraw/hidraw*")
+		for _, node := range nodes {
+			uevent, err := os.ReadFile(filepath.Join(node, "device", "uevent"))
+			if err == nil && bytes.Contains(uevent, []byte(id)) {
+				return "/dev/" + filepath.Base(node), nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("no hidraw device for %04x:%04x", vid, pid)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Host is a fake Switch talking to a controller through hidraw
+type Host struct {
+	fp      *os.File
+	Timeout time.Duration
+	count   byte
+}
+
+// Open opens the hidraw node at path
+func Open(path string) (*Host, error) {
+	fp, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Host{fp: fp, Timeout: 2 * time.Second}, nil
+}
+
+// Close closes the hidraw node
+func (h *Host) Close() error {
+	return h.fp.Close()
+}
+
+// Send writes an output report, padded to the report length
+func (h *Host) Send(report ...byte) error {
+	buf := make([]byte, 64)
+	copy(buf, report)
+	_, err := h.fp.Write(buf)
+	return err
+}
+
+// Expect reads input reports until one starts with prefix
+func (h *Host) Expect(prefix ...byte) ([]byte, error) {
+	deadline := time.Now().Add(h.Timeout)
+	if err := h.fp.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 64)
+	for {
+		n, err := h.fp.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, fmt.Errorf("no report % x within %s", prefix, h.Timeout)
+		} else if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(buf[:n], prefix) {
+			return append([]byte(nil), buf[:n]...), nil
+		}
+	}
+}
+
+// Command sends the USB command 0x80 cmd and waits for its 0x81 reply
+func (h *Host) Command(cmd byte) ([]byte, error) {
+	if err := h.Send(0x80, cmd); err != nil {
+		return nil, err
+	}
+	return h.Expect(0x81, cmd)
+}
+
+// Subcommand sends the UART subcommand sub with args and waits for its 0x21 reply
+func (h *Host) Subcommand(sub byte, args ...byte) ([]byte, error) {
+	h.count = (h.count + 1) & 0x0f
+	// Report ID, counter, neutral rumble data for both motors, subcommand
+	report := []byte{0x01, h.count, 0x00, 0x01, 0x40, 0x40, 0x00, 0x01, 0x40, 0x40, sub}
+	if err := h.Send(append(report, args...)...); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(h.Timeout)
+	for time.Now().Before(deadline) {
+		reply, err := h.Expect(0x21)
+		if err != nil {
+			return nil, err
+		}
+		if reply[14] == sub {
+			return reply, nil
+		}
+	}
+	return nil, fmt.Errorf("no reply to subcommand 0x%02x", sub)
+}
+
+// hasModule reports whether a kernel module is loaded or built in
+func hasModule(name string) bool {
+	_, err := os.Stat("/sys/module/" + strings.ReplaceAll(name, "-", "_"))
+	return err == nil
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hosttest
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testWriter sends the log of a run to the test log
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// TestRun plays the Switch against the emulator through dummy_hcd. It loads
+// kernel modules and creates a gadget, so it only runs as root with
+// NSCON_HOSTTEST=1:
+//
+//	sudo NSCON_HOSTTEST=1 go test ./hosttest
+func TestRun(t *testing.T) {
+	if os.Getenv("NSCON_HOSTTEST") != "1" {
+		t.Skip("set NSCON_HOSTTEST=1 to run against dummy_hcd")
+	}
+	if os.Geteuid() != 0 {
+		t.Fatal("NSCON_HOSTTEST needs root")
+	}
+	err := Run(Options{
+		Logger: slog.New(slog.NewTextHandler(testWriter{t}, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package hotplug watches the kernel uevents announcing input event devices
+// and hidg nodes as they come and go.
+package hotplug
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Action is the kind of change an uevent announces
+type Action string
+
+const (
+	Add    Action = "add"
+	Remove Action = "remove"
+)
+
+// Subsystems of the devices reported by a Watcher
+const (
+	Input = "input"
+	Hidg  = "hidg"
+)
+
+// Event announces an input event device or hidg node
+type Event struct {
+	Action    Action
+	Subsystem string
+	// Node is the device node, e.g. /dev/input/event3 or /dev/hidg0
+	Node string
+	// SysPath is the directory of the device in sysfs
+	SysPath string
+}
+
+// kernelGroup is the multicast group of the uevents sent by the kernel, as
+// opposed to the ones udev resends after processing them
+const kernelGroup = 1
+
+// Watcher receives the uevents of the kernel
+type Watcher struct {
+	f   *os.File
+	buf []byte
+}
+
+// Open subscribes to the uevents of the kernel
+func Open() (*Watcher, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: kernelGroup}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// A non-blocking file is served by the runtime poller, so Close ends a pending Read
+	return &Watcher{f: os.NewFile(uintptr(fd), "uevent"), buf: make([]byte, 16384)}, nil
+}
+
+// Close closes the socket, which also ends a pending Read
+func (w *Watcher) Close() error {
+	return w.f.Close()
+}
+
+// Read blocks until an input event device or hidg node is added or removed
+func (w *Watcher) Read() (Event, error) {
+	for {
+		n, err := w.f.Read(w.buf)
+		if err != nil {
+			return Event{}, err
+		}
+		if e, ok := parse(w.buf[:n]); ok {
+			return e, nil
+		}
+	}
+}
+
+// parse decodes an uevent: a header "ACTION@DEVPATH" followed by
+// KEY=VALUE pairs, all terminated by NUL bytes
+func parse(b []byte) (Event, bool) {
+	fields := bytes.Split(b, []byte{0})
+	if len(fields) == 0 || !bytes.Contains(fields[0], []byte("@")) {
+		return Event{}, false
+	}
+	env := make(map[string]string)
+	for _, field := range fields[1:] {
+		if key, value, ok := strings.Cut(string(field), "="); ok {
+			env[key] = value
+		}
+	}
+	return event(Action(env["ACTION"]), "/sys"+env["DEVPATH"], env)
+}
+
+// event builds the Event of a device with the uevent variables env, if it
+// is one a Watcher reports
+func event(action Action, sysPath string, env map[string]string) (Event, bool) {
+	if action != Add && action != Remove {
+		return Event{}, false
+	}
+	subsystem, name := env["SUBSYSTEM"], env["DEVNAME"]
+	switch {
+	case subsystem == Input && strings.HasPrefix(name, "input/event"):
+	case subsystem == Hidg && name != "":
+	default:
+		return Event{}, false
+	}
+	return Event{
+		Action:    action,
+		Subsystem: subsystem,
+		Node:      filepath.Join("/dev", name),
+		SysPath:   sysPath,
+	}, true
+}
+
+// Scan returns Add events for the input event devices and hidg nodes
+// present now, sorted by node, to pick up devices that were added before
+// the Watcher was opened
+func Scan() ([]Event, error) {
+	var events []Event
+	for _, pattern := range []string{"/sys/class/input/event*", "/sys/class/hidg/hidg*"} {
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			env, err := readUevent(filepath.Join(dir, "uevent"))
+			if err != nil {
+				// The device went away in the meantime
+				continue
+			}
+			sysPath, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				continue
+			}
+			env["SUBSYSTEM"] = filepath.Base(filepath.Dir(dir))
+			if e, ok := event(Add, sysPath, env); ok {
+				events = append(events, e)
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Node < events[j].Node })
+	return events, nil
+}
+
+// readUevent reads the KEY=VALUE lines of a uevent file in sysfs
+func readUevent(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			env[key] = value
+		}
+	}
+	return env, scanner.Err()
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hotplug
+
+import (
+	"strings"
+	"testing"
+)
+
+// uevent joins the header and variables of an uevent as the kernel sends it
+func uevent(fields ...string) []byte {
+	return []byte(strings.Join(fields, "\x00") + "\x00")
+}
+
+const padPath = "/devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2:1.0/0003:045E:028E.0005/input/input21"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		event Event
+		ok    bool
+	}{
+		{
+			name: "input event device added",
+			data: uevent("add@"+padPath+"/event18", "ACTION=add", "DEVPATH="+padPath+"/event18",
+				"SUBSYSTEM=input", "MAJOR=13", "MINOR=82", "DEVNAME=input/event18", "SEQNUM=4711"),
+			event: Event{Action: Add, Subsystem: Input, Node: "/dev/input/event18", SysPath: "/sys" + padPath + "/event18"},
+			ok:    true,
+		},
+		{
+			name: "input event device removed",
+			data: uevent("remove@"+padPath+"/event18", "ACTION=remove", "DEVPATH="+padPath+"/event18",
+				"SUBSYSTEM=input", "MAJOR=13", "MINOR=82", "DEVNAME=input/event18", "SEQNUM=4730"),
+			event: Event{Action: Remove, Subsystem: Input, Node: "/dev/input/event18", SysPath: "/sys" + padPath + "/event18"},
+			ok:    true,
+		},
+		{
+			name: "hidg node added",
+			data: uevent("add@/devices/platform/dummy_udc.0/gadget.0/hidg/hidg0", "ACTION=add",
+				"DEVPATH=/devices/platform/dummy_udc.0/gadget.0/hidg/hidg0", "SUBSYSTEM=hidg",
+				"MAJOR=239", "MINOR=0", "DEVNAME=hidg0", "SEQNUM=4802"),
+			event: Event{Action: Add, Subsystem: Hidg, Node: "/dev/hidg0", SysPath: "/sys/devices/platform/dummy_udc.0/gadget.0/hidg/hidg0"},
+			ok:    true,
+		},
+		{
+			name: "input device without node",
+			data: uevent("add@"+padPath, "ACTION=add", "DEVPATH="+padPath, "SUBSYSTEM=input",
+				"PRODUCT=3/45e/28e/114", "NAME=\"Microsoft X-Box 360 pad\"", "SEQNUM=4709"),
+		},
+		{
+			name: "joystick node",
+			data: uevent("add@"+padPath+"/js0", "ACTION=add", "DEVPATH="+padPath+"/js0",
+				"SUBSYSTEM=input", "MAJOR=13", "MINOR=0", "DEVNAME=input/js0", "SEQNUM=4710"),
+		},
+		{
+			name: "change",
+			data: uevent("change@"+padPath+"/event18", "ACTION=change", "DEVPATH="+padPath+"/event18",
+				"SUBSYSTEM=input", "DEVNAME=input/event18", "SEQNUM=4712"),
+		},
+		{
+			name: "udev message",
+			data: append([]byte("libudev\x00\xfe\xed\xca\xfe"), uevent("ACTION=add", "SUBSYSTEM=input", "DEVNAME=input/event18")...),
+		},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := parse(tt.data)
+			if ok != tt.ok || event != tt.event {
+				t.Errorf("parse = %+v, %v, want %+v, %v", event, ok, tt.event, tt.ok)
+			}
+		})
+	}
+}
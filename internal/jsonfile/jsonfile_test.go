@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package jsonfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "slots.json")
+	for _, v := range []map[string]int{{"a": 1}, {"b": 2}} {
+		if err := Write(path, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n\t\"b\": 2\n}\n"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o644 {
+		t.Errorf("mode %v, %v", info.Mode(), err)
+	}
+	// No temporary files are left behind
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %v, %v", entries, err)
+	}
+	if err := Write(path, func() {}); err == nil {
+		t.Error("wrote a func")
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package kbm drives a controller with keyboards and mice through a mapping
+// profile.
+package kbm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+	"github.com/lmLumos/nscon/mapping"
+)
+
+// UpdateInterval is the interval at which the mouse motion is turned into
+// stick positions
+const UpdateInterval = 15 * time.Millisecond
+
+// DefaultExit are the keys ending Run of a Source that grabs its devices,
+// so that a grabbed keyboard can always be taken back
+var DefaultExit = []uint16{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTALT, evdev.KEY_ESC}
+
+// Source feeds the events of keyboards and mice into a controller. It
+// implements manager.Source.
+type Source struct {
+	// Paths are the event devices of the keyboards and mice
+	Paths []string
+	// Profile maps the keys, buttons and mouse motion, mapping.KeyboardMouse if nil
+	Profile *mapping.Profile
+	// Grab takes the devices exclusively, so that the console or desktop
+	// doesn't receive the keys too
+	Grab bool
+	// Exit are keys that end Run when held together, DefaultExit if nil and
+	// Grab is set
+	Exit []uint16
+}
+
+// Find returns the event devices of the keyboards and mice present
+func Find() ([]string, error) {
+	paths, err := filepath.Glob("/dev/input/event*")
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, path := range paths {
+		device, err := evdev.Open(path)
+		if err != nil {
+			continue
+		}
+		caps, err := device.Capabilities()
+		device.Close()
+		if err == nil && (caps.IsKeyboard() || caps.IsMouse()) {
+			found = append(found, path)
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// Run maps the events of the devices onto con until ctx is cancelled, the
+// exit keys are pressed or a device fails
+func (s *Source) Run(ctx context.Context, con *nscon.Controller) error {
+	if len(s.Paths) == 0 {
+		return errors.New("no keyboard or mouse")
+	}
+	profile := s.Profile
+	if profile == nil {
+		profile = mapping.KeyboardMouse
+	}
+	mapper, err := profile.NewMapper(nil)
+	if err != nil {
+		return err
+	}
+	exit := s.Exit
+	if exit == nil && s.Grab {
+		exit = DefaultExit
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var devices []*evdev.Device
+	for _, path := range s.Paths {
+		device, err := evdev.Open(path)
+		if err != nil {
+			return err
+		}
+		if _, err := device.GrabUntil(ctx, s.Grab); err != nil {
+			device.Close()
+			return err
+		}
+		devices = append(devices, device)
+	}
+
+	events := make(chan evdev.Event)
+	errc := make(chan error, len(devices))
+	for _, device := range devices {
+		go read(ctx, device, events, errc)
+	}
+
+	ticker := time.NewTicker(UpdateInterval)
+	defer ticker.Stop()
+	down := make(map[uint16]bool)
+	for {
+		select {
+		case e := <-events:
+			mapper.Apply(&con.Input, e)
+			if e.Type == evdev.EV_KEY && len(exit) > 0 {
+				down[e.Code] = e.Value != 0
+				if allDown(down, exit) {
+					return nil
+				}
+			}
+		case now := <-ticker.C:
+			mapper.Update(&con.Input, now)
+		case err := <-errc:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func read(ctx context.Context, device *evdev.Device, events chan<- evdev.Event, errc chan<- error) {
+	for {
+		e, err := device.ReadEvent()
+		if err != nil {
+			errc <- fmt.Errorf("%s: %w", device.Path, err)
+			return
+		}
+		select {
+		case events <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func allDown(down map[uint16]bool, keys []uint16) bool {
+	for _, key := range keys {
+		if !down[key] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package manager
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+	"github.com/lmLumos/nscon/mapping"
+	"github.com/lmLumos/nscon/motion"
+)
+
+// Gamepad is a Source mapping the input device at Path with Mappings, and
+// the motion sensors of PlayStation controllers onto the IMU
+type Gamepad struct {
+	Path     string
+	Mappings *mapping.Mappings
+	// Grab takes the device exclusively, so that the console or desktop
+	// doesn't react to it too
+	Grab bool
+	// Logger logs the device and, at debug level, its mapped events,
+	// slog.Default if nil
+	Logger *slog.Logger
+}
+
+// Run maps the input events until ctx is cancelled or the device is gone
+func (g Gamepad) Run(ctx context.Context, con *nscon.Controller) error {
+	logger := g.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	device, err := evdev.Open(g.Path)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+	stop, err := device.GrabUntil(ctx, g.Grab)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	mappings := g.Mappings
+	if mappings == nil {
+		mappings = &mapping.Mappings{Logger: logger}
+	}
+	mapper, profile, err := mappings.Mapper(device)
+	if err != nil {
+		return err
+	}
+	name, _ := device.Name()
+	logger.Info("reading input events", "path", g.Path, "name", name, "profile", profile.Name)
+
+	// PlayStation controllers report their motion on a second device
+	if node, err := motion.Find(g.Path); err == nil && node != "" {
+		logger.Info("reading motion sensors", "path", node)
+		go func() {
+			if err := motion.Run(ctx, node, con); err != nil {
+				logger.Warn("motion sensors stopped", "path", node, "err", err)
+			}
+		}()
+	}
+
+	for {
+		event, err := device.ReadEvent()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if mapper.Apply(&con.Input, event) {
+			logger.Debug("event", "code", evdev.CodeName(event.Type, event.Code), "value", event.Value)
+		}
+	}
+}
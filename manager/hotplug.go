@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+	"github.com/lmLumos/nscon/hotplug"
+)
+
+// Hotplug attaches gamepads to free player slots as they are plugged in and
+// detaches them when they are unplugged. Each player gets the lowest hidg
+// node not in use; gamepads wait until a node and a slot are free.
+//
+// With Slots set, gamepads get back the player and hidg node they had
+// before if both are free, and new gamepads get slots no other known
+// gamepad had.
+type Hotplug struct {
+	Manager *Manager
+	// NewSource creates the source of player reading the input device at node
+	NewSource func(player int, node string) Source
+	// NewController creates the controller of player on the hidg node,
+	// nscon.NewController if nil
+	NewController func(player int, hidg string) *nscon.Controller
+	// IsGamepad reports whether the input device at node should get a player,
+	// by default whether it has the buttons of a joystick or gamepad
+	IsGamepad func(node string) bool
+	// Slots, if set, remembers the slots of the gamepads
+	Slots *Slots
+
+	hidgs      map[string]bool // hidg nodes present, true if in use
+	waiting    []string        // gamepads without a player, in the order they appeared
+	identities map[string]string
+	players    map[string]attached
+	done       chan attached
+}
+
+// attached is a gamepad driving a player through a hidg node
+type attached struct {
+	player  int
+	gamepad string
+	hidg    string
+}
+
+// Run attaches the gamepads present and then follows the uevents until ctx
+// is cancelled. The players it attached are removed before it returns.
+func (h *Hotplug) Run(ctx context.Context) error {
+	w, err := hotplug.Open()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	events := make(chan hotplug.Event)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			e, err := w.Read()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Scan after subscribing, so that no device slips through in between
+	present, err := hotplug.Scan()
+	if err != nil {
+		return err
+	}
+
+	h.hidgs = make(map[string]bool)
+	h.waiting = nil
+	h.identities = make(map[string]string)
+	h.players = make(map[string]attached)
+	h.done = make(chan attached)
+	defer h.detachAll()
+
+	for _, e := range present {
+		h.handle(e)
+	}
+	for {
+		select {
+		case e := <-events:
+			h.handle(e)
+		case a := <-h.done:
+			h.released(a)
+		case err := <-errc:
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (h *Hotplug) handle(e hotplug.Event) {
+	logger := h.Manager.logger()
+	switch {
+	case e.Subsystem == hotplug.Hidg && e.Action == hotplug.Add:
+		if _, ok := h.hidgs[e.Node]; !ok {
+			h.hidgs[e.Node] = false
+			logger.Info("hidg node added", "node", e.Node)
+		}
+
+	case e.Subsystem == hotplug.Hidg && e.Action == hotplug.Remove:
+		delete(h.hidgs, e.Node)
+		for gamepad, a := range h.players {
+			if a.hidg == e.Node {
+				h.detach(gamepad)
+				h.waiting = append(h.waiting, gamepad)
+			}
+		}
+		logger.Info("hidg node removed", "node", e.Node)
+
+	case e.Subsystem == hotplug.Input && e.Action == hotplug.Add:
+		if _, ok := h.players[e.Node]; ok || h.isWaiting(e.Node) || !h.isGamepad(e.Node) {
+			return
+		}
+		h.waiting = append(h.waiting, e.Node)
+		h.identities[e.Node] = h.identity(e.Node)
+		logger.Info("gamepad added", "node", e.Node, "identity", h.identities[e.Node])
+
+	case e.Subsystem == hotplug.Input && e.Action == hotplug.Remove:
+		if h.detach(e.Node) || h.unwait(e.Node) {
+			delete(h.identities, e.Node)
+			logger.Info("gamepad removed", "node", e.Node)
+		}
+	}
+	h.attach()
+}
+
+// attach pairs waiting gamepads with free hidg nodes and player slots.
+// Gamepads failing to attach keep waiting for the next event to try again.
+func (h *Hotplug) attach() {
+	var failed []string
+	defer func() { h.waiting = append(failed, h.waiting...) }()
+	for len(h.waiting) > 0 {
+		gamepad := h.waiting[0]
+		identity := h.identities[gamepad]
+		player, hidg := h.pick(identity)
+		if hidg == "" || player == 0 {
+			return
+		}
+		h.waiting = h.waiting[1:]
+
+		con := nscon.NewController(hidg)
+		if h.NewController != nil {
+			con = h.NewController(player, hidg)
+		}
+		a := attached{player: player, gamepad: gamepad, hidg: hidg}
+		if err := h.Manager.Add(player, con, h.source(a)); err != nil {
+			h.Manager.logger().Warn("attaching gamepad failed", "node", gamepad, "hidg", hidg, "err", err)
+			failed = append(failed, gamepad)
+			continue
+		}
+		h.hidgs[hidg] = true
+		h.players[gamepad] = a
+		h.Manager.logger().Info("gamepad attached", "node", gamepad, "hidg", hidg, "player", player)
+
+		if h.Slots != nil && identity != "" {
+			if err := h.Slots.Set(identity, Slot{Player: player, Hidg: hidg}); err != nil {
+				h.Manager.logger().Warn("storing slot failed", "identity", identity, "err", err)
+			}
+		}
+	}
+}
+
+// pick returns the player and hidg node for the gamepad with identity, or
+// 0 and "" if none are free
+func (h *Hotplug) pick(identity string) (int, string) {
+	var players []int
+	for player := 1; player <= MaxPlayers; player++ {
+		if h.Manager.Controller(player) == nil {
+			players = append(players, player)
+		}
+	}
+	var hidgs []string
+	for hidg, used := range h.hidgs {
+		if !used {
+			hidgs = append(hidgs, hidg)
+		}
+	}
+	if len(players) == 0 || len(hidgs) == 0 {
+		return 0, ""
+	}
+	sort.Strings(hidgs)
+	player, hidg := players[0], hidgs[0]
+	if h.Slots == nil {
+		return player, hidg
+	}
+
+	if slot, ok := h.Slots.Get(identity); ok && identity != "" &&
+		slices.Contains(players, slot.Player) && slices.Contains(hidgs, slot.Hidg) {
+		return slot.Player, slot.Hidg
+	}
+	// Keep the slots of the other known gamepads for when they come back
+	reservedPlayers := map[int]bool{}
+	reservedHidgs := map[string]bool{}
+	for _, slot := range h.Slots.Others(identity) {
+		reservedPlayers[slot.Player] = true
+		reservedHidgs[slot.Hidg] = true
+	}
+	if i := slices.IndexFunc(players, func(p int) bool { return !reservedPlayers[p] }); i >= 0 {
+		player = players[i]
+	}
+	if i := slices.IndexFunc(hidgs, func(n string) bool { return !reservedHidgs[n] }); i >= 0 {
+		hidg = hidgs[i]
+	}
+	return player, hidg
+}
+
+// source wraps the source of a so that Run learns when it returns
+func (h *Hotplug) source(a attached) Source {
+	src := h.NewSource(a.player, a.gamepad)
+	done := h.done
+	return SourceFunc(func(ctx context.Context, con *nscon.Controller) error {
+		err := src.Run(ctx, con)
+		// Run may be gone already when the slot is removed on shutdown
+		select {
+		case done <- a:
+		case <-ctx.Done():
+		}
+		return err
+	})
+}
+
+// released frees the hidg node of a player whose source returned by itself
+func (h *Hotplug) released(a attached) {
+	if h.players[a.gamepad] != a {
+		return
+	}
+	delete(h.players, a.gamepad)
+	if _, ok := h.hidgs[a.hidg]; ok {
+		h.hidgs[a.hidg] = false
+	}
+	h.attach()
+}
+
+// detach removes the player of gamepad and reports whether it had one
+func (h *Hotplug) detach(gamepad string) bool {
+	a, ok := h.players[gamepad]
+	if !ok {
+		return false
+	}
+	delete(h.players, gamepad)
+	h.Manager.Remove(a.player)
+	if _, ok := h.hidgs[a.hidg]; ok {
+		h.hidgs[a.hidg] = false
+	}
+	return true
+}
+
+func (h *Hotplug) detachAll() {
+	for gamepad := range h.players {
+		h.detach(gamepad)
+	}
+}
+
+func (h *Hotplug) isWaiting(gamepad string) bool {
+	for _, node := range h.waiting {
+		if node == gamepad {
+			return true
+		}
+	}
+	return false
+}
+
+// unwait drops gamepad from the waiting gamepads and reports whether it was one
+func (h *Hotplug) unwait(gamepad string) bool {
+	for i, node := range h.waiting {
+		if node == gamepad {
+			h.waiting = append(h.waiting[:i], h.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// identity returns the identity of the gamepad at node if Slots is set
+func (h *Hotplug) identity(node string) string {
+	if h.Slots == nil {
+		return ""
+	}
+	device, err := evdev.Open(node)
+	if err != nil {
+		return ""
+	}
+	defer device.Close()
+
+	identity, err := device.Identity()
+	if err != nil {
+		h.Manager.logger().Warn("reading gamepad identity failed", "node", node, "err", err)
+	}
+	return identity
+}
+
+func (h *Hotplug) isGamepad(node string) bool {
+	if h.IsGamepad != nil {
+		return h.IsGamepad(node)
+	}
+	device, err := evdev.Open(node)
+	if err != nil {
+		return false
+	}
+	defer device.Close()
+
+	caps, err := device.Capabilities()
+	return err == nil && caps.IsJoystick()
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package manager
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/lmLumos/nscon"
+)
+
+func TestAttachFailureKeepsWaiting(t *testing.T) {
+	m := New()
+	m.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	defer m.Close()
+	// Connecting fails, there is no hidg node behind the path
+	hidg := filepath.Join(t.TempDir(), "hidg0")
+	h := &Hotplug{
+		Manager: m,
+		NewSource: func(player int, node string) Source {
+			return SourceFunc(func(ctx context.Context, con *nscon.Controller) error {
+				<-ctx.Done()
+				return nil
+			})
+		},
+		hidgs:      map[string]bool{hidg: false},
+		waiting:    []string{"/dev/input/event10", "/dev/input/event11"},
+		identities: map[string]string{},
+		players:    map[string]attached{},
+		done:       make(chan attached),
+	}
+
+	h.attach()
+	if want := []string{"/dev/input/event10", "/dev/input/event11"}; !slices.Equal(h.waiting, want) {
+		t.Errorf("waiting %v, want %v", h.waiting, want)
+	}
+	if len(h.players) != 0 || h.hidgs[hidg] {
+		t.Errorf("players %v, hidgs %v", h.players, h.hidgs)
+	}
+	if m.Controller(1) != nil {
+		t.Error("player 1 still in use")
+	}
+}
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package manager runs several emulated controllers side by side, one per
+// player slot. Each slot pairs a sink, the Controller talking to the Switch,
+// with a source feeding its input.
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/lmLumos/nscon"
+)
+
+// MaxPlayers is the number of player slots of the Switch
+const MaxPlayers = 8
+
+// ErrClosed is returned when adding a slot to a closed Manager
+var ErrClosed = errors.New("manager closed")
+
+// Source feeds the input of one player into con until ctx is cancelled or
+// the source fails, e.g. because its device was unplugged
+type Source interface {
+	Run(ctx context.Context, con *nscon.Controller) error
+}
+
+// SourceFunc adapts a function to a Source
+type SourceFunc func(ctx context.Context, con *nscon.Controller) error
+
+// Run calls f(ctx, con)
+func (f SourceFunc) Run(ctx context.Context, con *nscon.Controller) error {
+	return f(ctx, con)
+}
+
+type slot struct {
+	con    *nscon.Controller
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Manager owns the player slots. Its methods are safe for concurrent use.
+type Manager struct {
+	Logger *slog.Logger
+	// Released, if set, is called once a slot has been freed, with the error
+	// of its source or nil if the slot was removed
+	Released func(player int, err error)
+
+	mu     sync.Mutex
+	slots  map[int]*slot
+	closed bool
+}
+
+// New creates an instance of Manager with no slots in use
+func New() *Manager {
+	return &Manager{slots: make(map[int]*slot)}
+}
+
+func (m *Manager) logger() *slog.Logger {
+	if m.Logger != nil {
+		return m.Logger
+	}
+	return slog.Default()
+}
+
+// Add connects con and starts src for player, numbered from 1 to MaxPlayers.
+// The slot stays in use until it is removed or src returns.
+func (m *Manager) Add(player int, con *nscon.Controller, src Source) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	if player < 1 || player > MaxPlayers {
+		m.mu.Unlock()
+		return fmt.Errorf("player %d: must be between 1 and %d", player, MaxPlayers)
+	}
+	if _, ok := m.slots[player]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("player %d: slot in use", player)
+	}
+	// Reserve the slot, connecting may block until the host reads
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &slot{con: con, cancel: cancel, done: make(chan struct{})}
+	m.slots[player] = s
+	m.mu.Unlock()
+
+	err := con.Connect()
+	if err == nil && ctx.Err() != nil {
+		con.Close()
+		err = errors.New("removed while connecting")
+	}
+	if err != nil {
+		m.mu.Lock()
+		if m.slots[player] == s {
+			delete(m.slots, player)
+		}
+		m.mu.Unlock()
+		cancel()
+		close(s.done)
+		return fmt.Errorf("player %d: %w", player, err)
+	}
+	m.logger().Info("player added", "player", player)
+
+	go m.run(ctx, player, s, src)
+	return nil
+}
+
+func (m *Manager) run(ctx context.Context, player int, s *slot, src Source) {
+	err := src.Run(ctx, s.con)
+	if ctx.Err() != nil {
+		err = nil
+	}
+	s.cancel()
+
+	m.mu.Lock()
+	if m.slots[player] == s {
+		delete(m.slots, player)
+	}
+	m.mu.Unlock()
+
+	s.con.Close()
+	if err != nil {
+		m.logger().Warn("player source stopped", "player", player, "err", err)
+	} else {
+		m.logger().Info("player removed", "player", player)
+	}
+	if m.Released != nil {
+		m.Released(player, err)
+	}
+	close(s.done)
+}
+
+// Free returns the lowest player slot not in use, or 0 if all are taken
+func (m *Manager) Free() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for player := 1; player <= MaxPlayers; player++ {
+		if _, ok := m.slots[player]; !ok {
+			return player
+		}
+	}
+	return 0
+}
+
+// Remove stops the source of player and closes its controller. It returns
+// after both are done, or false if the slot was not in use.
+func (m *Manager) Remove(player int) bool {
+	m.mu.Lock()
+	s, ok := m.slots[player]
+	delete(m.slots, player)
+	m.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+	s.cancel()
+	<-s.done
+	return true
+}
+
+// Players returns the slots in use in ascending order
+func (m *Manager) Players() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	players := make([]int, 0, len(m.slots))
+	for player := range m.slots {
+		players = append(players, player)
+	}
+	sort.Ints(players)
+	return players
+}
+
+// Controller returns the controller of player, or nil if the slot is not in use
+func (m *Manager) Controller(player int) *nscon.Controller {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.slots[player]; ok {
+		return s.con
+	}
+	return nil
+}
+
+// Close removes all slots and waits for their sources to return. Add fails
+// afterwards.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	slots := m.slots
+	m.slots = make(map[int]*slot)
+	m.mu.Unlock()
+
+	for _, s := range slots {
+		s.cancel()
+	}
+	for _, s := range slots {
+		<-s.done
+	}
+}
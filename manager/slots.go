@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/lmLumos/nscon/internal/jsonfile"
+)
+
+// Slot is the player and hidg node assigned to a device
+type Slot struct {
+	Player int    `json:"player"`
+	Hidg   string `json:"hidg"`
+}
+
+// Slots remembers the slot of each device by its identity, see
+// evdev.Device.Identity, in a JSON file. Its methods are safe for
+// concurrent use.
+type Slots struct {
+	path  string
+	mu    sync.Mutex
+	slots map[string]Slot
+}
+
+// LoadSlots reads the slots stored at path. A missing file holds no slots.
+func LoadSlots(path string) (*Slots, error) {
+	s := &Slots{path: path, slots: make(map[string]Slot)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.slots); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Get returns the slot of the device with identity
+func (s *Slots) Get(identity string) (Slot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slot, ok := s.slots[identity]
+	return slot, ok
+}
+
+// Others returns the slots assigned to devices other than identity
+func (s *Slots) Others(identity string) []Slot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var others []Slot
+	for other, slot := range s.slots {
+		if other != identity {
+			others = append(others, slot)
+		}
+	}
+	return others
+}
+
+// Set assigns slot to the device with identity and stores all slots
+func (s *Slots) Set(identity string, slot Slot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slots[identity] == slot {
+		return nil
+	}
+	s.slots[identity] = slot
+	return s.save()
+}
+
+func (s *Slots) save() error {
+	return jsonfile.Write(s.path, s.slots)
+}
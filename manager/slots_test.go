@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSlotsRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "slots.json")
+	slots, err := LoadSlots(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := slots.Get("usb-pad-1"); ok {
+		t.Error("slot in a missing file")
+	}
+
+	want := map[string]Slot{
+		"usb-pad-1": {Player: 1, Hidg: "/dev/hidg0"},
+		"bt-pad-2":  {Player: 3, Hidg: "/dev/hidg2"},
+	}
+	for identity, slot := range want {
+		if err := slots.Set(identity, slot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Moving a device replaces its slot
+	want["usb-pad-1"] = Slot{Player: 2, Hidg: "/dev/hidg1"}
+	if err := slots.Set("usb-pad-1", want["usb-pad-1"]); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSlots(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for identity, slot := range want {
+		if got, ok := loaded.Get(identity); !ok || got != slot {
+			t.Errorf("%s: got %+v, %v, want %+v", identity, got, ok, slot)
+		}
+	}
+	if others := loaded.Others("usb-pad-1"); len(others) != 1 || others[0] != want["bt-pad-2"] {
+		t.Errorf("others %+v, want %+v", others, want["bt-pad-2"])
+	}
+}
+
+func TestLoadSlotsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slots.json")
+	if err := os.WriteFile(path, []byte(`{"usb-pad-1": {"player": "one"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSlots(path); err == nil {
+		t.Error("no error loading invalid slots")
+	}
+}
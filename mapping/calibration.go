@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/lmLumos/nscon/evdev"
+	"github.com/lmLumos/nscon/internal/jsonfile"
+)
+
+// CalibrationSectors is the number of directions in which
+// NewStickCalibration measures the roundness of a stick
+const CalibrationSectors = 32
+
+// StickCalibration corrects the center, range and roundness of a stick
+// with two axes, in raw axis values
+type StickCalibration struct {
+	// X and Y are the axes of the stick, like "ABS_X" and "ABS_Y"
+	X      string   `json:"x"`
+	Y      string   `json:"y"`
+	Center [2]int32 `json:"center"`
+	Min    [2]int32 `json:"min"`
+	Max    [2]int32 `json:"max"`
+	// Radius is the distance from the center reached at full deflection in
+	// evenly spaced directions, counterclockwise from +X, once the range is
+	// applied: 1 for a round gate, more in the corners of a square one
+	Radius []float64 `json:"radius,omitempty"`
+	// Noise is the largest distance from the center measured at rest, the
+	// smallest useful deadzone
+	Noise float64 `json:"noise"`
+}
+
+// NewStickCalibration calibrates the stick with the axes x and y from
+// samples taken at rest and while circling the edge
+func NewStickCalibration(x, y string, rest, edge [][2]int32) (StickCalibration, error) {
+	if len(rest) == 0 || len(edge) == 0 {
+		return StickCalibration{}, errors.New("no samples")
+	}
+	c := StickCalibration{X: x, Y: y, Min: edge[0], Max: edge[0]}
+	var sum [2]float64
+	for _, s := range rest {
+		sum[0] += float64(s[0])
+		sum[1] += float64(s[1])
+	}
+	for i := range c.Center {
+		c.Center[i] = int32(math.Round(sum[i] / float64(len(rest))))
+	}
+	for _, s := range append(append([][2]int32(nil), rest...), edge...) {
+		for i := range s {
+			c.Min[i] = min(c.Min[i], s[i])
+			c.Max[i] = max(c.Max[i], s[i])
+		}
+	}
+	if err := c.validate(); err != nil {
+		return StickCalibration{}, fmt.Errorf("%s/%s: the stick didn't move: %w", x, y, err)
+	}
+
+	for _, s := range rest {
+		n := c.scale(s)
+		c.Noise = max(c.Noise, math.Hypot(n[0], n[1]))
+	}
+
+	c.Radius = make([]float64, CalibrationSectors)
+	for _, s := range edge {
+		n := c.scale(s)
+		i := sector(math.Atan2(n[1], n[0]), len(c.Radius))
+		c.Radius[i] = max(c.Radius[i], math.Hypot(n[0], n[1]))
+	}
+	// Directions the stick never went fall back to the nearest ones on both sides
+	for i, r := range c.Radius {
+		if r > 0 {
+			continue
+		}
+		var before, after float64
+		for d := 1; d < len(c.Radius) && (before == 0 || after == 0); d++ {
+			if before == 0 {
+				before = c.Radius[(i-d+len(c.Radius))%len(c.Radius)]
+			}
+			if after == 0 {
+				after = c.Radius[(i+d)%len(c.Radius)]
+			}
+		}
+		c.Radius[i] = (before + after) / 2
+	}
+	return c, nil
+}
+
+// sector returns the nearest of n evenly spaced directions to angle
+func sector(angle float64, n int) int {
+	return int(math.Round(angle/(2*math.Pi)*float64(n))+float64(n)) % n
+}
+
+func (c StickCalibration) validate() error {
+	for i := range c.Center {
+		if c.Min[i] >= c.Center[i] || c.Center[i] >= c.Max[i] {
+			return fmt.Errorf("range %d..%d around %d", c.Min[i], c.Max[i], c.Center[i])
+		}
+	}
+	return nil
+}
+
+// scale maps the raw values v onto [-1, 1] by the center and range
+func (c StickCalibration) scale(v [2]int32) [2]float64 {
+	var n [2]float64
+	for i := range v {
+		d := float64(v[i] - c.Center[i])
+		if d >= 0 {
+			n[i] = d / float64(c.Max[i]-c.Center[i])
+		} else {
+			n[i] = d / float64(c.Center[i]-c.Min[i])
+		}
+	}
+	return n
+}
+
+// radius interpolates Radius in the direction of angle
+func (c StickCalibration) radius(angle float64) float64 {
+	n := len(c.Radius)
+	if n == 0 {
+		return 1
+	}
+	pos := math.Mod(angle/(2*math.Pi)*float64(n)+float64(n), float64(n))
+	i := int(pos) % n
+	frac := pos - math.Floor(pos)
+	return c.Radius[i]*(1-frac) + c.Radius[(i+1)%n]*frac
+}
+
+// Normalize maps the raw values x and y onto the unit circle, so that the
+// stick reads 0 at rest and 1 at full deflection in every direction
+func (c StickCalibration) Normalize(x, y int32) [2]float64 {
+	n := c.scale([2]int32{x, y})
+	if r := c.radius(math.Atan2(n[1], n[0])); r > 0 {
+		n[0], n[1] = n[0]/r, n[1]/r
+	}
+	if d := math.Hypot(n[0], n[1]); d > 1 {
+		n[0], n[1] = n[0]/d, n[1]/d
+	}
+	return n
+}
+
+// Calibration holds the calibrated sticks of a device
+type Calibration struct {
+	Sticks []StickCalibration `json:"sticks"`
+}
+
+// calibratedStick is a calibrated pair of axes, with the last raw values
+type calibratedStick struct {
+	StickCalibration
+	raw  [2]int32
+	axes [2]*axisMapping
+}
+
+// Calibrate normalizes the axes of the sticks in cal with their
+// calibration instead of the range the device reports. The noise at rest
+// bounds the radial deadzone of the sticks from below.
+func (m *Mapper) Calibrate(cal Calibration) error {
+	for _, sc := range cal.Sticks {
+		if err := sc.validate(); err != nil {
+			return fmt.Errorf("calibration of %s/%s: %w", sc.X, sc.Y, err)
+		}
+		c := &calibratedStick{StickCalibration: sc, raw: sc.Center}
+		for i, name := range []string{sc.X, sc.Y} {
+			typ, code, err := evdev.ParseCode(name)
+			if err != nil || typ != evdev.EV_ABS {
+				return fmt.Errorf("calibration of %s/%s: %s is not an absolute axis", sc.X, sc.Y, name)
+			}
+			if a, ok := m.axes[code]; ok && a.stick != nil {
+				a.calibrated, a.calibratedAxis = c, i
+				c.axes[i] = a
+				a.stick.raiseDeadzone(sc.Noise)
+			}
+		}
+	}
+	return nil
+}
+
+// Calibrations stores the calibration of each device by its identity, see
+// evdev.Device.Identity, in a JSON file. Its methods are safe for
+// concurrent use.
+type Calibrations struct {
+	path    string
+	mu      sync.Mutex
+	devices map[string]Calibration
+}
+
+// LoadCalibrations reads the calibrations stored at path. A missing file
+// holds none.
+func LoadCalibrations(path string) (*Calibrations, error) {
+	c := &Calibrations{path: path, devices: make(map[string]Calibration)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.devices); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the calibration of the device with identity
+func (c *Calibrations) Get(identity string) (Calibration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cal, ok := c.devices[identity]
+	return cal, ok
+}
+
+// Set stores cal as the calibration of the device with identity
+func (c *Calibrations) Set(identity string, cal Calibration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.devices[identity] = cal
+	return jsonfile.Write(c.path, c.devices)
+}
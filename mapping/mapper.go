@@ -0,0 +1,455 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+)
+
+type buttonTarget func(in *nscon.ControllerInput) *uint8
+
+var buttonTargets = map[string]buttonTarget{
+	"A":          func(in *nscon.ControllerInput) *uint8 { return &in.Button.A },
+	"B":          func(in *nscon.ControllerInput) *uint8 { return &in.Button.B },
+	"X":          func(in *nscon.ControllerInput) *uint8 { return &in.Button.X },
+	"Y":          func(in *nscon.ControllerInput) *uint8 { return &in.Button.Y },
+	"L":          func(in *nscon.ControllerInput) *uint8 { return &in.Button.L },
+	"R":          func(in *nscon.ControllerInput) *uint8 { return &in.Button.R },
+	"ZL":         func(in *nscon.ControllerInput) *uint8 { return &in.Button.ZL },
+	"ZR":         func(in *nscon.ControllerInput) *uint8 { return &in.Button.ZR },
+	"Plus":       func(in *nscon.ControllerInput) *uint8 { return &in.Button.Plus },
+	"Minus":      func(in *nscon.ControllerInput) *uint8 { return &in.Button.Minus },
+	"Home":       func(in *nscon.ControllerInput) *uint8 { return &in.Button.Home },
+	"Capture":    func(in *nscon.ControllerInput) *uint8 { return &in.Button.Capture },
+	"Up":         func(in *nscon.ControllerInput) *uint8 { return &in.Dpad.Up },
+	"Down":       func(in *nscon.ControllerInput) *uint8 { return &in.Dpad.Down },
+	"Left":       func(in *nscon.ControllerInput) *uint8 { return &in.Dpad.Left },
+	"Right":      func(in *nscon.ControllerInput) *uint8 { return &in.Dpad.Right },
+	"LeftStick":  func(in *nscon.ControllerInput) *uint8 { return &in.Stick.Left.Press },
+	"RightStick": func(in *nscon.ControllerInput) *uint8 { return &in.Stick.Right.Press },
+}
+
+var stickTargets = map[string]func(in *nscon.ControllerInput) *float64{
+	"LeftX":  func(in *nscon.ControllerInput) *float64 { return &in.Stick.Left.X },
+	"LeftY":  func(in *nscon.ControllerInput) *float64 { return &in.Stick.Left.Y },
+	"RightX": func(in *nscon.ControllerInput) *float64 { return &in.Stick.Right.X },
+	"RightY": func(in *nscon.ControllerInput) *float64 { return &in.Stick.Right.Y },
+}
+
+// stickDirections are the button targets pushing a stick, with the stick
+var stickDirections = map[string]string{
+	"LeftStickUp": "Left", "LeftStickDown": "Left", "LeftStickLeft": "Left", "LeftStickRight": "Left",
+	"RightStickUp": "Right", "RightStickDown": "Right", "RightStickLeft": "Right", "RightStickRight": "Right",
+}
+
+// mouseTargets are the axis targets of the sticks a mouse can drive
+var mouseTargets = map[string][2]string{
+	"LeftStick":  {"LeftX", "LeftY"},
+	"RightStick": {"RightX", "RightY"},
+}
+
+// hatTargets are the buttons pressed by the negative and positive directions of a hat
+var hatTargets = map[string][2]buttonTarget{
+	"DpadX": {buttonTargets["Left"], buttonTargets["Right"]},
+	"DpadY": {buttonTargets["Up"], buttonTargets["Down"]},
+}
+
+// stickAxes are the axis targets of the sticks: the stick and the index of the axis
+var stickAxes = map[string]struct {
+	stick string
+	axis  int
+}{
+	"LeftX": {"Left", 0}, "LeftY": {"Left", 1},
+	"RightX": {"Right", 0}, "RightY": {"Right", 1},
+}
+
+// stickState holds the deflection of a stick driven by axes before Stick shapes it
+type stickState struct {
+	Stick
+	x, y func(in *nscon.ControllerInput) *float64
+	raw  [2]float64
+}
+
+type axisMapping struct {
+	Axis
+	info  evdev.AbsInfo
+	stick *stickState
+	axis  int
+	// calibrated normalizes the stick axes with a calibration
+	calibrated     *calibratedStick
+	calibratedAxis int
+	hat            [2]buttonTarget
+	button         buttonTarget
+	trigger        evdev.Trigger
+}
+
+// buttonMapping presses target while all of its keys are down
+type buttonMapping struct {
+	keys   []uint16
+	target string
+}
+
+type mouseMapping struct {
+	Mouse
+	x, y   func(in *nscon.ControllerInput) *float64
+	dx, dy float64
+	last   time.Time
+
+	// The turn of the gyro since the last recenter in degrees, and whether
+	// it is being undone
+	recenterKey uint16
+	yaw, pitch  float64
+	recentering bool
+}
+
+// Mapper applies a profile to the events of one or more devices
+type Mapper struct {
+	buttons  map[uint16][]*buttonMapping // by each of their keys
+	byTarget map[string][]*buttonMapping
+	down     map[uint16]bool
+	axes     map[uint16]*axisMapping
+	sticks   map[string]*stickState
+	mouse    *mouseMapping
+}
+
+// NewMapper resolves the sources and targets of the profile for a device
+// whose absolute axes have the ranges infos
+func (p *Profile) NewMapper(infos map[uint16]evdev.AbsInfo) (*Mapper, error) {
+	m := &Mapper{
+		buttons:  make(map[uint16][]*buttonMapping),
+		byTarget: make(map[string][]*buttonMapping),
+		down:     make(map[uint16]bool),
+		axes:     make(map[uint16]*axisMapping),
+		sticks:   make(map[string]*stickState),
+	}
+	for name, stick := range p.Sticks {
+		if _, ok := stickTargets[name+"X"]; !ok {
+			return nil, fmt.Errorf("profile %s: unknown stick %q", p.Name, name)
+		}
+		if err := stick.validate(); err != nil {
+			return nil, fmt.Errorf("profile %s: stick %s: %w", p.Name, name, err)
+		}
+	}
+	switch p.Layout {
+	case "", LabelLayout, PositionalLayout:
+	default:
+		return nil, fmt.Errorf("profile %s: unknown layout %q", p.Name, p.Layout)
+	}
+	switch p.Labels {
+	case "", XboxLabels, NintendoLabels:
+	default:
+		return nil, fmt.Errorf("profile %s: unknown labels %q", p.Name, p.Labels)
+	}
+
+	for source, target := range p.Buttons {
+		b := &buttonMapping{target: p.target(target)}
+		if _, ok := buttonTargets[b.target]; !ok && stickDirections[b.target] == "" {
+			return nil, fmt.Errorf("profile %s: %s: unknown button %q", p.Name, source, target)
+		}
+		for _, key := range strings.Split(source, "+") {
+			typ, code, err := evdev.ParseCode(key)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+			if typ != evdev.EV_KEY {
+				return nil, fmt.Errorf("profile %s: %s is not a button", p.Name, key)
+			}
+			b.keys = append(b.keys, code)
+			m.buttons[code] = append(m.buttons[code], b)
+		}
+		m.byTarget[b.target] = append(m.byTarget[b.target], b)
+	}
+
+	for source, axis := range p.Axes {
+		typ, code, err := evdev.ParseCode(source)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		if typ != evdev.EV_ABS {
+			return nil, fmt.Errorf("profile %s: %s is not an absolute axis", p.Name, source)
+		}
+		a := &axisMapping{
+			Axis:    axis,
+			info:    infos[code],
+			hat:     hatTargets[axis.Target],
+			button:  buttonTargets[p.target(axis.Target)],
+			trigger: evdev.Trigger{Press: axis.Press, Release: axis.Release},
+		}
+		if sa, ok := stickAxes[axis.Target]; ok {
+			a.stick, a.axis = m.stick(p, sa.stick), sa.axis
+			// The flat the device reports bounds the radial deadzone from
+			// below instead of cutting a square out of the center
+			a.stick.raiseDeadzone(a.info.FlatFraction())
+		}
+		if a.stick == nil && a.hat[0] == nil && a.button == nil {
+			return nil, fmt.Errorf("profile %s: %s: unknown target %q", p.Name, source, axis.Target)
+		}
+		if a.Deadzone < 0 || a.Deadzone >= 1 {
+			return nil, fmt.Errorf("profile %s: %s: deadzone must be in [0, 1)", p.Name, source)
+		}
+		if a.button != nil {
+			if err := a.trigger.Validate(); err != nil {
+				return nil, fmt.Errorf("profile %s: %s: %w", p.Name, source, err)
+			}
+		}
+		if a.Scale == 0 {
+			a.Scale = 1
+		}
+		m.axes[code] = a
+	}
+
+	if p.Mouse != nil {
+		mouse := &mouseMapping{Mouse: *p.Mouse}
+		axes, ok := mouseTargets[mouse.Target]
+		if !ok && mouse.Target != "Gyro" {
+			return nil, fmt.Errorf("profile %s: mouse: unknown target %q", p.Name, mouse.Target)
+		}
+		if mouse.Recenter != "" {
+			typ, code, err := evdev.ParseCode(mouse.Recenter)
+			if err != nil || typ != evdev.EV_KEY {
+				return nil, fmt.Errorf("profile %s: mouse: recenter %q is not a button", p.Name, mouse.Recenter)
+			}
+			mouse.recenterKey = code
+		}
+		if mouse.Sensitivity < 0 || mouse.Acceleration < 0 {
+			return nil, fmt.Errorf("profile %s: mouse: sensitivity and acceleration must not be negative", p.Name)
+		}
+		if mouse.Sensitivity == 0 {
+			mouse.Sensitivity = 1
+		}
+		if ok {
+			mouse.x, mouse.y = stickTargets[axes[0]], stickTargets[axes[1]]
+		}
+		m.mouse = mouse
+	}
+	return m, nil
+}
+
+// stick returns the state of the stick called name, "Left" or "Right"
+func (m *Mapper) stick(p *Profile, name string) *stickState {
+	st, ok := m.sticks[name]
+	if !ok {
+		st = &stickState{
+			Stick: p.Sticks[name],
+			x:     stickTargets[name+"X"],
+			y:     stickTargets[name+"Y"],
+		}
+		m.sticks[name] = st
+	}
+	return st
+}
+
+// Apply updates in according to e and reports whether e is mapped
+func (m *Mapper) Apply(in *nscon.ControllerInput, e evdev.Event) bool {
+	switch e.Type {
+	case evdev.EV_KEY:
+		if m.mouse != nil && m.mouse.Recenter != "" && e.Code == m.mouse.recenterKey && e.Value == 1 {
+			m.mouse.recentering = true
+		}
+		mappings, ok := m.buttons[e.Code]
+		if !ok {
+			return false
+		}
+		// Leave the buttons alone on key repeats
+		if e.Value == 2 {
+			return true
+		}
+		m.down[e.Code] = e.Value != 0
+		for _, b := range mappings {
+			m.press(in, b.target)
+		}
+		return true
+	case evdev.EV_REL:
+		if m.mouse != nil && (e.Code == evdev.REL_X || e.Code == evdev.REL_Y) {
+			if e.Code == evdev.REL_X {
+				m.mouse.dx += float64(e.Value)
+			} else {
+				m.mouse.dy += float64(e.Value)
+			}
+			return true
+		}
+	case evdev.EV_ABS:
+		if a, ok := m.axes[e.Code]; ok {
+			a.apply(in, e.Value)
+			return true
+		}
+	}
+	return false
+}
+
+// held reports whether all keys of a mapping to target are down
+func (m *Mapper) held(target string) bool {
+	for _, b := range m.byTarget[target] {
+		down := true
+		for _, key := range b.keys {
+			down = down && m.down[key]
+		}
+		if down {
+			return true
+		}
+	}
+	return false
+}
+
+// press updates the button or stick direction target from the keys down
+func (m *Mapper) press(in *nscon.ControllerInput, target string) {
+	if button, ok := buttonTargets[target]; ok {
+		*button(in) = boolToUint8(m.held(target))
+		return
+	}
+	stick := stickDirections[target]
+	x := m.direction(stick+"StickRight", stick+"StickLeft")
+	y := m.direction(stick+"StickUp", stick+"StickDown")
+	// Keep diagonals on the circle of the stick
+	if x != 0 && y != 0 {
+		x, y = x*math.Sqrt2/2, y*math.Sqrt2/2
+	}
+	*stickTargets[stick+"X"](in) = x
+	*stickTargets[stick+"Y"](in) = y
+}
+
+// direction returns 1 or -1 if only the target pos or neg is held, else 0
+func (m *Mapper) direction(pos, neg string) float64 {
+	return float64(boolToUint8(m.held(pos))) - float64(boolToUint8(m.held(neg)))
+}
+
+// maxGyroRate is the fastest turn used to recenter the gyro, in degrees
+// per second, below the range of the IMU
+const maxGyroRate = 1500
+
+// Update moves the stick or turns the gyro driven by the mouse by the speed
+// of the motion since the last call. Sources of profiles with a Mouse call
+// it regularly, so that the stick returns to the center and the gyro stops
+// turning when the mouse stops.
+func (m *Mapper) Update(in *nscon.ControllerInput, now time.Time) {
+	mouse := m.mouse
+	if mouse == nil {
+		return
+	}
+	dt := now.Sub(mouse.last).Seconds()
+	dx, dy := mouse.dx, mouse.dy
+	mouse.dx, mouse.dy, mouse.last = 0, 0, now
+	if dt <= 0 || dt > 1 {
+		return
+	}
+
+	// Speed in 1000 counts per second
+	vx, vy := dx/dt/1000, dy/dt/1000
+	gain := mouse.Sensitivity * (1 + mouse.Acceleration*math.Hypot(vx, vy))
+	if mouse.InvertY {
+		vy = -vy
+	}
+	if mouse.x == nil {
+		mouse.turn(in, vx*gain*10, vy*gain*10, dt)
+		return
+	}
+
+	// The mouse reports forward motion as negative Y
+	x, y := vx*gain, -vy*gain
+	if r := math.Hypot(x, y); r > 1 {
+		x, y = x/r, y/r
+	}
+	*mouse.x(in), *mouse.y(in) = x, y
+}
+
+// turn sets the gyro to yaw right at rightRate and pitch down at downRate
+// in degrees per second, or to undo the turn since the last recenter, and
+// tilts the accelerometer with the pitch
+func (mouse *mouseMapping) turn(in *nscon.ControllerInput, rightRate, downRate, dt float64) {
+	// Yawing right turns clockwise around Z, pitching the top up
+	// counterclockwise around Y
+	yawRate, pitchRate := -rightRate, downRate
+	if mouse.recentering {
+		yawRate = max(-maxGyroRate, min(-mouse.yaw/dt, maxGyroRate))
+		pitchRate = max(-maxGyroRate, min(-mouse.pitch/dt, maxGyroRate))
+	}
+	mouse.yaw += yawRate * dt
+	mouse.pitch += pitchRate * dt
+	if mouse.recentering && math.Abs(mouse.yaw) < 1e-6 && math.Abs(mouse.pitch) < 1e-6 {
+		mouse.yaw, mouse.pitch, mouse.recentering = 0, 0, false
+	}
+
+	in.IMU.Gyro.X, in.IMU.Gyro.Y, in.IMU.Gyro.Z = 0, pitchRate, yawRate
+	pitch := max(-90, min(mouse.pitch, 90)) * math.Pi / 180
+	in.IMU.Accel.X, in.IMU.Accel.Y, in.IMU.Accel.Z = -math.Sin(pitch), 0, math.Cos(pitch)
+}
+
+func (a *axisMapping) apply(in *nscon.ControllerInput, value int32) {
+	switch {
+	case a.calibrated != nil:
+		// The calibration needs both axes, so both follow
+		c := a.calibrated
+		c.raw[a.calibratedAxis] = value
+		n := c.Normalize(c.raw[0], c.raw[1])
+		for i, axis := range c.axes {
+			if axis != nil {
+				axis.applyStick(in, n[i])
+			}
+		}
+
+	case a.stick != nil:
+		a.applyStick(in, a.info.NormalizeWithoutFlat(value))
+
+	case a.hat[0] != nil:
+		if a.Invert {
+			value = -value
+		}
+		*a.hat[0](in) = boolToUint8(value < 0)
+		*a.hat[1](in) = boolToUint8(value > 0)
+
+	default:
+		v := a.info.NormalizeUnsigned(value)
+		if a.Invert {
+			v = 1 - v
+		}
+		v = max(0, min(applyDeadzone(v, a.Deadzone)*a.Scale, 1))
+		// Only follow changes, so that a digital button reported alongside
+		// the axis isn't overridden
+		if pressed, changed := a.trigger.Update(v); changed {
+			*a.button(in) = boolToUint8(pressed)
+		}
+	}
+}
+
+// applyStick moves the stick axis to the normalized deflection v
+func (a *axisMapping) applyStick(in *nscon.ControllerInput, v float64) {
+	v = applyDeadzone(v, a.Deadzone)
+	if a.Invert {
+		v = -v
+	}
+	st := a.stick
+	st.raw[a.axis] = max(-1, min(v*a.Scale, 1))
+	*st.x(in), *st.y(in) = st.Apply(st.raw[0], st.raw[1])
+}
+
+// raiseDeadzone makes dz the deadzone of the stick if it is larger and
+// leaves travel before the outer deadzone
+func (st *stickState) raiseDeadzone(dz float64) {
+	if dz > st.Deadzone && dz+st.OuterDeadzone < 1 {
+		st.Deadzone = dz
+	}
+}
+
+// applyDeadzone reads v within dz of 0 as 0 and rescales the rest to keep the full range
+func applyDeadzone(v, dz float64) float64 {
+	if dz <= 0 {
+		return v
+	}
+	if math.Abs(v) <= dz {
+		return 0
+	}
+	return math.Copysign((math.Abs(v)-dz)/(1-dz), v)
+}
+
+func boolToUint8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
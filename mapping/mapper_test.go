@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+)
+
+// stickInfos are the ranges of a gamepad whose left stick has a flat of 12.5%
+var stickInfos = map[uint16]evdev.AbsInfo{
+	evdev.ABS_X: {Minimum: -32768, Maximum: 32767, Flat: 4096},
+	evdev.ABS_Y: {Minimum: -32768, Maximum: 32767, Flat: 4096},
+}
+
+func moveLeftStick(t *testing.T, m *Mapper, x, y int32) (float64, float64) {
+	t.Helper()
+	var in nscon.ControllerInput
+	m.Apply(&in, evdev.Event{Type: evdev.EV_ABS, Code: evdev.ABS_X, Value: x})
+	m.Apply(&in, evdev.Event{Type: evdev.EV_ABS, Code: evdev.ABS_Y, Value: y})
+	return in.Stick.Left.X, in.Stick.Left.Y
+}
+
+func TestStickFlatIsRadial(t *testing.T) {
+	m, err := Default.NewMapper(stickInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Within the flat on one axis
+	if x, y := moveLeftStick(t, m, 3500, 0); x != 0 || y != 0 {
+		t.Errorf("inside the flat: %v, %v", x, y)
+	}
+	// A diagonal within the flat on each axis but beyond it from the center
+	x, y := moveLeftStick(t, m, 3500, -3500)
+	if x <= 0 || y <= 0 || math.Abs(x-y) > 1e-4 {
+		t.Errorf("diagonal beyond the flat: %v, %v", x, y)
+	}
+	// The full range is still reachable
+	if x, _ := moveLeftStick(t, m, 32767, 0); math.Abs(x-1) > 1e-3 {
+		t.Errorf("full deflection: %v", x)
+	}
+}
+
+func TestStickDeadzoneAboveFlat(t *testing.T) {
+	p := *Default
+	p.Sticks = map[string]Stick{"Left": {Deadzone: 0.2}, "Right": {}}
+	m, err := p.NewMapper(stickInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := m.sticks["Left"]; st.Deadzone != 0.2 {
+		t.Errorf("deadzone %v, want the profile's 0.2", st.Deadzone)
+	}
+	if x, _ := moveLeftStick(t, m, 5000, 0); x != 0 {
+		t.Errorf("inside the deadzone: %v", x)
+	}
+}
+
+func TestCalibrationNoiseDeadzone(t *testing.T) {
+	m, err := Default.NewMapper(stickInfos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Calibrate(Calibration{Sticks: []StickCalibration{{
+		X: "ABS_X", Y: "ABS_Y",
+		Center: [2]int32{1000, -1000},
+		Min:    [2]int32{-30000, -32000},
+		Max:    [2]int32{32000, 30000},
+		Noise:  0.2,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := m.sticks["Left"]; st.Deadzone != 0.2 {
+		t.Errorf("deadzone %v, want the noise 0.2", st.Deadzone)
+	}
+	// Within the noise around the calibrated center
+	if x, y := moveLeftStick(t, m, 5000, -4000); x != 0 || y != 0 {
+		t.Errorf("inside the noise: %v, %v", x, y)
+	}
+	if x, _ := moveLeftStick(t, m, 32000, -1000); math.Abs(x-1) > 1e-9 {
+		t.Errorf("full deflection: %v", x)
+	}
+}
+
+func moveMouse(m *Mapper, in *nscon.ControllerInput, dx, dy int32, now time.Time) {
+	m.Apply(in, evdev.Event{Type: evdev.EV_REL, Code: evdev.REL_X, Value: dx})
+	m.Apply(in, evdev.Event{Type: evdev.EV_REL, Code: evdev.REL_Y, Value: dy})
+	m.Update(in, now)
+}
+
+func TestMouseGyro(t *testing.T) {
+	p := &Profile{Name: "gyro", Mouse: &Mouse{Target: "Gyro", Sensitivity: 1, Recenter: "BTN_MIDDLE"}}
+	m, err := p.NewMapper(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in nscon.ControllerInput
+	start := time.Unix(1700000000, 0)
+	m.Update(&in, start)
+
+	// 100 counts right and down in 0.1 s yaw right and pitch down by 1
+	// degree at 10 degrees per second
+	moveMouse(m, &in, 100, 100, start.Add(100*time.Millisecond))
+	if g := in.IMU.Gyro; g.X != 0 || math.Abs(g.Y-10) > 1e-9 || math.Abs(g.Z+10) > 1e-9 {
+		t.Errorf("turn: gyro %+v, want Y 10 and Z -10", g)
+	}
+	pitch := math.Pi / 180
+	if a := in.IMU.Accel; math.Abs(a.X+math.Sin(pitch)) > 1e-9 || math.Abs(a.Z-math.Cos(pitch)) > 1e-9 {
+		t.Errorf("turn: accel %+v, want tilted by 1 degree", a)
+	}
+
+	// The gyro stops with the mouse
+	moveMouse(m, &in, 0, 0, start.Add(200*time.Millisecond))
+	if g := in.IMU.Gyro; g.Y != 0 || g.Z != 0 {
+		t.Errorf("still: gyro %+v", g)
+	}
+
+	// Recentering undoes the turn within the next update
+	m.Apply(&in, evdev.Event{Type: evdev.EV_KEY, Code: evdev.BTN_MIDDLE, Value: 1})
+	moveMouse(m, &in, 50, 0, start.Add(300*time.Millisecond))
+	if g := in.IMU.Gyro; math.Abs(g.Y+10) > 1e-9 || math.Abs(g.Z-10) > 1e-9 {
+		t.Errorf("recenter: gyro %+v, want Y -10 and Z 10", g)
+	}
+	if a := in.IMU.Accel; math.Abs(a.X) > 1e-9 || math.Abs(a.Z-1) > 1e-9 {
+		t.Errorf("recenter: accel %+v, want flat", a)
+	}
+	// and then follows the mouse again
+	moveMouse(m, &in, -100, 0, start.Add(400*time.Millisecond))
+	if g := in.IMU.Gyro; math.Abs(g.Z-10) > 1e-9 {
+		t.Errorf("after recenter: gyro %+v, want Z 10", g)
+	}
+}
+
+func TestMouseGyroRecenterRate(t *testing.T) {
+	p := &Profile{Name: "gyro", Mouse: &Mouse{Target: "Gyro", Sensitivity: 1, Recenter: "BTN_MIDDLE"}}
+	m, err := p.NewMapper(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in nscon.ControllerInput
+	start := time.Unix(1700000000, 0)
+	m.Update(&in, start)
+	// A 300 degree turn in a second
+	moveMouse(m, &in, 30000, 0, start.Add(time.Second))
+
+	// Undone at the fastest rate in 0.2 s, over two updates
+	m.Apply(&in, evdev.Event{Type: evdev.EV_KEY, Code: evdev.BTN_MIDDLE, Value: 1})
+	for i := 1; i <= 2; i++ {
+		moveMouse(m, &in, 0, 0, start.Add(time.Second+time.Duration(i)*100*time.Millisecond))
+		if in.IMU.Gyro.Z != maxGyroRate {
+			t.Errorf("recenter update %d: gyro %+v, want Z %d", i, in.IMU.Gyro, maxGyroRate)
+		}
+	}
+	moveMouse(m, &in, 0, 0, start.Add(time.Second+300*time.Millisecond))
+	if in.IMU.Gyro.Z != 0 {
+		t.Errorf("recentered: gyro %+v", in.IMU.Gyro)
+	}
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/lmLumos/nscon/evdev"
+)
+
+// Mappings picks the mapping of each input device: the first of Profiles
+// matching it, else its mapping in SDL, else Default
+type Mappings struct {
+	Profiles []*Profile
+	SDL      *SDLDB
+	// Layout overrides the layout of the profiles if set
+	Layout Layout
+	// Calibrations, if set, correct the sticks of the devices they hold
+	Calibrations *Calibrations
+	// Logger reports the mappings that don't apply, slog.Default if nil
+	Logger *slog.Logger
+}
+
+// DefaultCalibrationPath is where nscon calibrate stores the calibrations
+const DefaultCalibrationPath = "/var/lib/nscon/calibration.json"
+
+// FlagsUsage describes the command line flags of ParseFlags
+const FlagsUsage = `  --mapping=FILE      Load button and axis mapping profiles from FILE
+  --sdl-db=FILE       Load SDL controller mappings from FILE (gamecontrollerdb.txt)
+  --layout=MODE       Map face buttons by label (default) or positional
+  --calibration=FILE  Apply the stick calibrations in FILE (default ` + DefaultCalibrationPath + `, empty for none)`
+
+// ParseFlags applies the flags of FlagsUsage in args and returns the others.
+// Without --calibration it loads the calibrations at DefaultCalibrationPath.
+func (s *Mappings) ParseFlags(args []string) ([]string, error) {
+	var rest []string
+	calibrationPath := DefaultCalibrationPath
+	for _, arg := range args {
+		if path, ok := strings.CutPrefix(arg, "--mapping="); ok {
+			profiles, err := LoadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("mapping profiles: %w", err)
+			}
+			s.Profiles = profiles
+		} else if path, ok := strings.CutPrefix(arg, "--sdl-db="); ok {
+			db, err := LoadSDLFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("SDL mappings: %w", err)
+			}
+			s.SDL = db
+		} else if mode, ok := strings.CutPrefix(arg, "--layout="); ok {
+			s.Layout = Layout(mode)
+			if s.Layout != LabelLayout && s.Layout != PositionalLayout {
+				return nil, fmt.Errorf("unknown layout %q, use label or positional", mode)
+			}
+		} else if path, ok := strings.CutPrefix(arg, "--calibration="); ok {
+			calibrationPath = path
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	if calibrationPath != "" {
+		calibrations, err := LoadCalibrations(calibrationPath)
+		if err != nil {
+			return nil, fmt.Errorf("stick calibrations: %w", err)
+		}
+		s.Calibrations = calibrations
+	}
+	return rest, nil
+}
+
+func (s *Mappings) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// Mapper maps device and returns the profile it picked
+func (s *Mappings) Mapper(device *evdev.Device) (*Mapper, *Profile, error) {
+	axes, err := device.AbsInfos()
+	if err != nil {
+		return nil, nil, err
+	}
+	name, _ := device.Name()
+	id, _ := device.ID()
+	profile := Select(s.Profiles, "", name, id)
+	if profile == nil && s.SDL != nil {
+		if m := s.SDL.Lookup(id); m != nil {
+			caps, err := device.Capabilities()
+			if err != nil {
+				return nil, nil, err
+			}
+			if profile, err = m.Profile(caps); err != nil {
+				s.logger().Warn("ignoring SDL mapping", "device", name, "err", err)
+			}
+		}
+	}
+	if profile == nil {
+		profile = Default
+	}
+
+	p := *profile
+	if s.Layout != "" {
+		p.Layout = s.Layout
+	}
+	if p.Labels == "" {
+		p.Labels = DefaultLabels(id)
+	}
+	mapper, err := p.NewMapper(axes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.Calibrations != nil {
+		if identity, err := device.Identity(); err == nil {
+			if cal, ok := s.Calibrations.Get(identity); ok {
+				if err := mapper.Calibrate(cal); err != nil {
+					s.logger().Warn("ignoring stick calibration", "device", name, "err", err)
+				}
+			}
+		}
+	}
+	return mapper, profile, nil
+}
@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(path, []byte(`[{"name": "pad", "buttons": {"BTN_SOUTH": "B"}}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var s Mappings
+	calibrations := filepath.Join(t.TempDir(), "calibration.json")
+	rest, err := s.ParseFlags([]string{"/dev/input/event3", "--mapping=" + path, "--debug", "--layout=positional", "--calibration=" + calibrations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/dev/input/event3", "--debug"}; !slices.Equal(rest, want) {
+		t.Errorf("rest %v, want %v", rest, want)
+	}
+	if len(s.Profiles) != 1 || s.Profiles[0].Name != "pad" || s.Layout != PositionalLayout {
+		t.Errorf("profiles %v, layout %q", s.Profiles, s.Layout)
+	}
+	if s.Calibrations == nil || s.Calibrations.path != calibrations {
+		t.Errorf("calibrations %v, want them loaded from %s", s.Calibrations, calibrations)
+	}
+	if _, err := s.ParseFlags([]string{"--calibration="}); err != nil || s.Calibrations == nil {
+		t.Errorf("empty --calibration: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"--layout=diagonal"},
+		{"--mapping=" + filepath.Join(t.TempDir(), "missing.json")},
+		{"--sdl-db=" + filepath.Join(t.TempDir(), "missing.txt")},
+	} {
+		if _, err := new(Mappings).ParseFlags(append(args, "--calibration=")); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package mapping maps the buttons and axes of input devices onto
+// ControllerInput according to profiles loaded from JSON files.
+//
+// A profile file holds a list of profiles:
+//
+//	[{
+//		"name": "xbox",
+//		"match": [{"name": "Xbox", "vendor": "045e"}],
+//		"layout": "positional",
+//		"buttons": {"BTN_SOUTH": "A", "BTN_EAST": "B", "BTN_MODE": "Home"},
+//		"axes": {
+//			"ABS_X": {"target": "LeftX"},
+//			"ABS_Y": {"target": "LeftY", "invert": true},
+//			"ABS_Z": {"target": "ZL", "press": 0.6, "release": 0.4},
+//			"ABS_HAT0X": {"target": "DpadX"}
+//		},
+//		"sticks": {
+//			"Left": {"deadzone": 0.08, "outerDeadzone": 0.02},
+//			"Right": {"deadzone": 0.05, "antiDeadzone": 0.1, "curve": {"exponent": 2}}
+//		}
+//	}]
+//
+// Sources are evdev code names. Button targets are the fields of
+// ControllerInput.Button and .Dpad, plus LeftStick and RightStick for the
+// stick presses and LeftStickUp, LeftStickDown, LeftStickLeft, ... to push
+// a stick all the way. Button sources joined with +, like
+// "KEY_LEFTCTRL+KEY_H", press the target while all of them are down. Axis
+// targets are LeftX, LeftY, RightX, RightY, DpadX and DpadY, or a button
+// target to press it past a threshold. The layout is "label" or
+// "positional", see Profile. The sticks are shaped after their axes are
+// mapped, see Stick.
+//
+// The motion of a mouse drives a stick or the gyroscope with
+//
+//	"mouse": {"target": "RightStick", "sensitivity": 1.5, "acceleration": 0.5}
+//	"mouse": {"target": "Gyro", "sensitivity": 2, "recenter": "BTN_MIDDLE"}
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lmLumos/nscon/evdev"
+)
+
+// ID is a USB vendor or product ID, written as a hex string in JSON
+type ID uint16
+
+func (id *ID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("ID must be a hex string like \"057e\": %s", b)
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return fmt.Errorf("invalid ID %q", s)
+	}
+	*id = ID(v)
+	return nil
+}
+
+func (id ID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%04x", uint16(id)))
+}
+
+// Match selects the devices a profile applies to. Empty fields match any device.
+type Match struct {
+	// Name is matched case-insensitively as a substring of the device name
+	Name    string `json:"name,omitempty"`
+	Vendor  ID     `json:"vendor,omitempty"`
+	Product ID     `json:"product,omitempty"`
+}
+
+// Matches reports whether the device called name with id matches
+func (m Match) Matches(name string, id evdev.ID) bool {
+	return (m.Name == "" || strings.Contains(strings.ToLower(name), strings.ToLower(m.Name))) &&
+		(m.Vendor == 0 || uint16(m.Vendor) == id.Vendor) &&
+		(m.Product == 0 || uint16(m.Product) == id.Product)
+}
+
+// Axis maps an axis onto a target
+type Axis struct {
+	Target string `json:"target"`
+	// Invert flips the direction of the axis
+	Invert bool `json:"invert,omitempty"`
+	// Scale multiplies the normalized value, 1 if zero
+	Scale float64 `json:"scale,omitempty"`
+	// Deadzone is the normalized distance from rest that reads as 0, on this
+	// axis alone. Stick has deadzones keeping the diagonals.
+	Deadzone float64 `json:"deadzone,omitempty"`
+	// Press and Release are the thresholds of button targets, see evdev.Trigger
+	Press   float64 `json:"press,omitempty"`
+	Release float64 `json:"release,omitempty"`
+}
+
+// Mouse maps the motion of a mouse onto a stick or the gyroscope, see
+// Mapper.Update
+type Mouse struct {
+	// Target is LeftStick, RightStick or Gyro, which turns the controller
+	// like the mouse moves, for aiming in games with gyro controls
+	Target string `json:"target"`
+	// Sensitivity is the deflection of the stick at a speed of 1000 counts
+	// per second, or the turn of the gyro in degrees per 100 counts, 1 if zero
+	Sensitivity float64 `json:"sensitivity,omitempty"`
+	// Acceleration raises the deflection at higher speeds: it is multiplied by
+	// 1 + Acceleration × speed, in 1000 counts per second
+	Acceleration float64 `json:"acceleration,omitempty"`
+	// InvertY pushes the stick down or tilts the gyro down when the mouse
+	// moves forward
+	InvertY bool `json:"invertY,omitempty"`
+	// Recenter is a button that turns the gyro back to where it was at the
+	// start or the last recenter
+	Recenter string `json:"recenter,omitempty"`
+}
+
+// Layout decides which Switch buttons the face buttons of a device press
+type Layout string
+
+const (
+	// LabelLayout presses the button with the label printed on the face
+	// button, e.g. A for the bottom button of an Xbox controller
+	LabelLayout Layout = "label"
+	// PositionalLayout presses the button at the same position, e.g. B for
+	// the bottom button of any controller
+	PositionalLayout Layout = "positional"
+)
+
+// Labels names the convention of the labels printed on the face buttons
+type Labels string
+
+const (
+	// XboxLabels have A at the bottom and Y at the top. PlayStation
+	// controllers follow it with cross as A.
+	XboxLabels Labels = "xbox"
+	// NintendoLabels have B at the bottom and X at the top
+	NintendoLabels Labels = "nintendo"
+)
+
+// DefaultLabels guesses the labels of the device with id from its vendor
+func DefaultLabels(id evdev.ID) Labels {
+	if id.Vendor == 0x057e {
+		return NintendoLabels
+	}
+	return XboxLabels
+}
+
+// Profile maps the buttons and axes of matching devices.
+//
+// The face button targets A, B, X and Y name the buttons at the positions of
+// these letters on an Xbox controller, A at the bottom. Layout and Labels
+// then decide which Switch buttons they press.
+type Profile struct {
+	Name    string            `json:"name"`
+	Match   []Match           `json:"match,omitempty"`
+	Layout  Layout            `json:"layout,omitempty"`
+	Labels  Labels            `json:"labels,omitempty"`
+	Buttons map[string]string `json:"buttons,omitempty"`
+	Axes    map[string]Axis   `json:"axes,omitempty"`
+	Mouse   *Mouse            `json:"mouse,omitempty"`
+	// Sticks shape the sticks driven by axes, by "Left" and "Right"
+	Sticks map[string]Stick `json:"sticks,omitempty"`
+}
+
+// faceSwap exchanges the face buttons between Xbox and Switch positions
+var faceSwap = map[string]string{"A": "B", "B": "A", "X": "Y", "Y": "X"}
+
+// target returns the Switch button pressed by the target t of the profile
+func (p *Profile) target(t string) string {
+	if p.Layout == PositionalLayout || p.Labels == NintendoLabels {
+		if swapped, ok := faceSwap[t]; ok {
+			return swapped
+		}
+	}
+	return t
+}
+
+// Load reads a list of profiles and checks their sources and targets
+func Load(r io.Reader) ([]*Profile, error) {
+	var profiles []*Profile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&profiles); err != nil {
+		return nil, err
+	}
+	for _, p := range profiles {
+		if _, err := p.NewMapper(nil); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// LoadFile reads a list of profiles from the file at path
+func LoadFile(path string) ([]*Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	profiles, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+// Select returns the profile called name if name is not empty, otherwise
+// the first profile matching the device called deviceName with id. It
+// returns nil if there is none.
+func Select(profiles []*Profile, name, deviceName string, id evdev.ID) *Profile {
+	for _, p := range profiles {
+		if name != "" {
+			if p.Name == name {
+				return p
+			}
+			continue
+		}
+		for _, m := range p.Match {
+			if m.Matches(deviceName, id) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// Default maps a gamepad reporting the standard Linux gamepad codes
+var Default = &Profile{
+	Name: "default",
+	Buttons: map[string]string{
+		"BTN_SOUTH": "A", "BTN_EAST": "B", "BTN_NORTH": "Y", "BTN_WEST": "X",
+		"BTN_TL": "L", "BTN_TR": "R", "BTN_TL2": "ZL", "BTN_TR2": "ZR",
+		"BTN_SELECT": "Minus", "BTN_START": "Plus", "BTN_MODE": "Home",
+		"BTN_THUMBL": "LeftStick", "BTN_THUMBR": "RightStick",
+		"BTN_DPAD_UP": "Up", "BTN_DPAD_DOWN": "Down", "BTN_DPAD_LEFT": "Left", "BTN_DPAD_RIGHT": "Right",
+	},
+	Axes: map[string]Axis{
+		"ABS_X":     {Target: "LeftX"},
+		"ABS_Y":     {Target: "LeftY", Invert: true},
+		"ABS_RX":    {Target: "RightX"},
+		"ABS_RY":    {Target: "RightY", Invert: true},
+		"ABS_Z":     {Target: "ZL"},
+		"ABS_RZ":    {Target: "ZR"},
+		"ABS_BRAKE": {Target: "ZL"},
+		"ABS_GAS":   {Target: "ZR"},
+		"ABS_HAT0X": {Target: "DpadX"},
+		"ABS_HAT0Y": {Target: "DpadY"},
+	},
+	Sticks: DefaultSticks,
+}
+
+// DefaultSticks give both sticks a small radial deadzone
+var DefaultSticks = map[string]Stick{
+	"Left":  {Deadzone: 0.05},
+	"Right": {Deadzone: 0.05},
+}
+
+// KeyboardMouse moves the left stick with WASD and the right stick with the
+// mouse, and fires ZR and ZL with the mouse buttons. It leaves Esc alone as
+// Ctrl+Alt+Esc is kbm.DefaultExit.
+var KeyboardMouse = &Profile{
+	Name:   "keyboard-mouse",
+	Labels: XboxLabels,
+	Buttons: map[string]string{
+		"KEY_W": "LeftStickUp", "KEY_S": "LeftStickDown", "KEY_A": "LeftStickLeft", "KEY_D": "LeftStickRight",
+		"KEY_LEFTSHIFT": "LeftStick", "BTN_MIDDLE": "RightStick",
+		"KEY_UP": "Up", "KEY_DOWN": "Down", "KEY_LEFT": "Left", "KEY_RIGHT": "Right",
+		"KEY_ENTER": "A", "KEY_SPACE": "B", "KEY_DOT": "X", "KEY_SLASH": "Y",
+		"KEY_Q": "L", "KEY_E": "R", "KEY_TAB": "ZL", "KEY_BACKSLASH": "ZR",
+		"BTN_RIGHT": "ZL", "BTN_LEFT": "ZR",
+		"KEY_G": "Plus", "KEY_F": "Minus", "KEY_H": "Home", "KEY_GRAVE": "Capture",
+	},
+	Mouse: &Mouse{Target: "RightStick", Acceleration: 0.5},
+}
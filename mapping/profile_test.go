@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+)
+
+func TestProfileTarget(t *testing.T) {
+	tests := []struct {
+		layout Layout
+		labels Labels
+		swap   bool
+	}{
+		{"", "", false},
+		{LabelLayout, XboxLabels, false},
+		{LabelLayout, NintendoLabels, true},
+		{PositionalLayout, XboxLabels, true},
+		// A Nintendo pad is already laid out like the Switch
+		{PositionalLayout, NintendoLabels, true},
+	}
+	for _, tt := range tests {
+		p := &Profile{Layout: tt.layout, Labels: tt.labels}
+		for from, swapped := range faceSwap {
+			want := from
+			if tt.swap {
+				want = swapped
+			}
+			if got := p.target(from); got != want {
+				t.Errorf("layout %q labels %q: target(%s) = %s, want %s", tt.layout, tt.labels, from, got, want)
+			}
+		}
+		if got := p.target("Home"); got != "Home" {
+			t.Errorf("layout %q labels %q: target(Home) = %s", tt.layout, tt.labels, got)
+		}
+	}
+}
+
+func TestLayoutPressesButton(t *testing.T) {
+	for layout, want := range map[Layout]string{LabelLayout: "A", PositionalLayout: "B"} {
+		p := *Default
+		p.Layout = layout
+		m, err := p.NewMapper(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var in nscon.ControllerInput
+		m.Apply(&in, evdev.Event{Type: evdev.EV_KEY, Code: evdev.BTN_SOUTH, Value: 1})
+		got := map[string]uint8{"A": in.Button.A, "B": in.Button.B}
+		if got[want] != 1 || got["A"]+got["B"] != 1 {
+			t.Errorf("layout %s: BTN_SOUTH pressed A=%d B=%d, want %s", layout, in.Button.A, in.Button.B, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	profiles, err := Load(strings.NewReader(`[{
+		"name": "pad",
+		"match": [{"name": "Pad", "vendor": "0x045E", "product": "028e"}],
+		"layout": "positional",
+		"buttons": {"BTN_SOUTH": "A", "KEY_LEFTCTRL+KEY_H": "Home"},
+		"axes": {"ABS_Z": {"target": "ZL", "press": 0.6, "release": 0.4}},
+		"sticks": {"Left": {"deadzone": 0.1}}
+	}, {"name": "empty"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("got %d profiles, want 2", len(profiles))
+	}
+	p := profiles[0]
+	if p.Name != "pad" || p.Layout != PositionalLayout || p.Buttons["KEY_LEFTCTRL+KEY_H"] != "Home" {
+		t.Errorf("got %+v", p)
+	}
+	if len(p.Match) != 1 || p.Match[0].Vendor != 0x045e || p.Match[0].Product != 0x028e {
+		t.Errorf("match %+v", p.Match)
+	}
+	if a := p.Axes["ABS_Z"]; a.Target != "ZL" || a.Press != 0.6 || a.Release != 0.4 {
+		t.Errorf("axis %+v", a)
+	}
+	if s := p.Sticks["Left"]; s.Deadzone != 0.1 {
+		t.Errorf("stick %+v", s)
+	}
+	if Select(profiles, "", "Xbox Pad", evdev.ID{Vendor: 0x045e, Product: 0x028e}) != p {
+		t.Error("profile doesn't match its device")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":         `[{"name": "p", "button": {"BTN_SOUTH": "A"}}]`,
+		"unknown axis key":    `[{"name": "p", "axes": {"ABS_X": {"target": "LeftX", "inverted": true}}}]`,
+		"unknown match key":   `[{"name": "p", "match": [{"vendorId": "045e"}]}]`,
+		"unknown source":      `[{"name": "p", "buttons": {"BTN_NOPE": "A"}}]`,
+		"unknown target":      `[{"name": "p", "buttons": {"BTN_SOUTH": "C"}}]`,
+		"unknown axis target": `[{"name": "p", "axes": {"ABS_X": {"target": "LeftZ"}}}]`,
+		"unknown layout":      `[{"name": "p", "layout": "mirrored"}]`,
+		"unknown stick":       `[{"name": "p", "sticks": {"Middle": {}}}]`,
+		"invalid trigger":     `[{"name": "p", "axes": {"ABS_Z": {"target": "ZL", "press": 0.3, "release": 0.5}}}]`,
+		"numeric ID":          `[{"name": "p", "match": [{"vendor": 1118}]}]`,
+		"not a list":          `{"name": "p"}`,
+		"truncated":           `[{"name": "p"`,
+	}
+	for name, data := range tests {
+		if _, err := Load(strings.NewReader(data)); err == nil {
+			t.Errorf("%s: no error loading %s", name, data)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(path, []byte(`[{"name": "p", "extra": 1}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), `"extra"`) {
+		t.Errorf("got error %v, want the path and the unknown key", err)
+	}
+}
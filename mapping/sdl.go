@@ -0,0 +1,337 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lmLumos/nscon/evdev"
+)
+
+// SDLMapping is one line of SDL's gamecontrollerdb.txt
+type SDLMapping struct {
+	GUID string
+	Name string
+	// Fields maps SDL buttons and axes like "a" or "leftx" to the joystick
+	// inputs like "b0", "a1~" or "h0.4"
+	Fields map[string]string
+}
+
+// SDLDB is a set of SDL mappings for Linux
+type SDLDB struct {
+	// mappings are in file order, a later line for a GUID replaces the earlier
+	mappings []*SDLMapping
+}
+
+// LoadSDL reads mappings in the gamecontrollerdb.txt format. Mappings for
+// other platforms are skipped.
+func LoadSDL(r io.Reader) (*SDLDB, error) {
+	db := &SDLDB{}
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, platform, err := parseSDLMapping(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if platform != "" && platform != "Linux" {
+			continue
+		}
+		if i, ok := index[m.GUID]; ok {
+			db.mappings[i] = m
+			continue
+		}
+		index[m.GUID] = len(db.mappings)
+		db.mappings = append(db.mappings, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// LoadSDLFile reads the mappings from the file at path
+func LoadSDLFile(path string) (*SDLDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := LoadSDL(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+func parseSDLMapping(line string) (m *SDLMapping, platform string, err error) {
+	fields := strings.Split(strings.TrimSuffix(line, ","), ",")
+	if len(fields) < 2 {
+		return nil, "", fmt.Errorf("missing GUID or name")
+	}
+	guid := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(guid); err != nil || len(b) != 16 {
+		return nil, "", fmt.Errorf("invalid GUID %q", fields[0])
+	}
+
+	m = &SDLMapping{GUID: guid, Name: fields[1], Fields: make(map[string]string)}
+	for _, field := range fields[2:] {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return nil, "", fmt.Errorf("%s: invalid field %q", m.Name, field)
+		}
+		if key == "platform" {
+			platform = value
+			continue
+		}
+		m.Fields[key] = value
+	}
+	return m, platform, nil
+}
+
+// SDLGUID returns the GUID SDL gives a Linux joystick with id: the bus,
+// vendor, product and version as little-endian 16-bit words each followed
+// by a zero word
+func SDLGUID(id evdev.ID) string {
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint16(b[0:], id.BusType)
+	binary.LittleEndian.PutUint16(b[4:], id.Vendor)
+	binary.LittleEndian.PutUint16(b[8:], id.Product)
+	binary.LittleEndian.PutUint16(b[12:], id.Version)
+	return hex.EncodeToString(b)
+}
+
+// Lookup returns the mapping of the joystick with id, ignoring the CRC of
+// the name newer SDL versions store in the GUID. Without a mapping for the
+// version it returns the one with the closest version, the first in the
+// file of equally close ones. It returns nil if there is none.
+func (db *SDLDB) Lookup(id evdev.ID) *SDLMapping {
+	want := SDLGUID(id)
+	var best *SDLMapping
+	bestDistance := 0
+	for _, m := range db.mappings {
+		if !sameDevice(m.GUID, want) {
+			continue
+		}
+		distance := int(sdlVersion(m.GUID)) - int(id.Version)
+		if distance < 0 {
+			distance = -distance
+		}
+		if best == nil || distance < bestDistance {
+			best, bestDistance = m, distance
+		}
+	}
+	return best
+}
+
+// sdlVersion returns the version in the seventh word of a GUID
+func sdlVersion(guid string) uint16 {
+	b, _ := hex.DecodeString(guid[24:28])
+	return binary.LittleEndian.Uint16(b)
+}
+
+// sameDevice compares two GUIDs of 32 hex digits skipping the CRC in the
+// second word and the version in the seventh
+func sameDevice(a, b string) bool {
+	for word := 0; word < 8; word++ {
+		if word == 1 || word == 6 {
+			continue
+		}
+		if a[word*4:word*4+4] != b[word*4:word*4+4] {
+			return false
+		}
+	}
+	return true
+}
+
+// sdlTargets maps SDL buttons and axes to the targets of profiles
+var sdlTargets = map[string]string{
+	"a": "A", "b": "B", "x": "X", "y": "Y",
+	"back": "Minus", "start": "Plus", "guide": "Home", "misc1": "Capture",
+	"leftshoulder": "L", "rightshoulder": "R",
+	"lefttrigger": "ZL", "righttrigger": "ZR",
+	"leftstick": "LeftStick", "rightstick": "RightStick",
+	"dpup": "Up", "dpdown": "Down", "dpleft": "Left", "dpright": "Right",
+	"leftx": "LeftX", "lefty": "LeftY", "rightx": "RightX", "righty": "RightY",
+}
+
+// sdlInputs numbers the inputs of a joystick the way SDL does on Linux
+type sdlInputs struct {
+	buttons []uint16
+	axes    []uint16
+	hats    []uint16
+}
+
+func newSDLInputs(caps evdev.Capabilities) sdlInputs {
+	var in sdlInputs
+	keys := append([]uint16(nil), caps[evdev.EV_KEY]...)
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	// Joystick buttons come first, then the codes below them
+	for _, code := range keys {
+		if code >= evdev.BTN_JOYSTICK {
+			in.buttons = append(in.buttons, code)
+		}
+	}
+	for _, code := range keys {
+		if code < evdev.BTN_JOYSTICK {
+			in.buttons = append(in.buttons, code)
+		}
+	}
+
+	abs := map[uint16]bool{}
+	for _, code := range caps[evdev.EV_ABS] {
+		abs[code] = true
+	}
+	for code := uint16(0); code < evdev.ABS_MAX; code++ {
+		if code >= evdev.ABS_HAT0X && code < evdev.ABS_HAT0X+8 {
+			if code%2 == 0 && (abs[code] || abs[code+1]) {
+				in.hats = append(in.hats, code)
+			}
+			continue
+		}
+		if abs[code] {
+			in.axes = append(in.axes, code)
+		}
+	}
+	return in
+}
+
+// hatDirections are the hat bits of SDL: the axis offset from the X axis
+// of the hat and the sign of the direction
+var hatDirections = map[int]struct {
+	offset uint16
+	sign   int
+}{
+	1: {1, -1}, // up
+	2: {0, 1},  // right
+	4: {1, 1},  // down
+	8: {0, -1}, // left
+}
+
+// Profile translates the mapping into a profile for a joystick with caps
+func (m *SDLMapping) Profile(caps evdev.Capabilities) (*Profile, error) {
+	in := newSDLInputs(caps)
+	p := &Profile{
+		Name:    "sdl:" + m.Name,
+		Labels:  XboxLabels,
+		Buttons: make(map[string]string),
+		Axes:    make(map[string]Axis),
+		Sticks:  DefaultSticks,
+	}
+	// SDL names the buttons by their position on an Xbox controller too
+	if guid, _ := hex.DecodeString(m.GUID); binary.LittleEndian.Uint16(guid[4:]) == 0x057e {
+		p.Labels = NintendoLabels
+	}
+
+	// The signs of the axes driving the directions of the d-pad
+	dpad := map[uint16]map[int]string{}
+
+	for key, input := range m.Fields {
+		target, ok := sdlTargets[key]
+		if !ok {
+			continue
+		}
+		invert := strings.HasSuffix(input, "~")
+		input = strings.TrimSuffix(input, "~")
+		sign := 0
+		if input != "" && (input[0] == '+' || input[0] == '-') {
+			sign = 1
+			if input[0] == '-' {
+				sign = -1
+			}
+			input = input[1:]
+		}
+		if input == "" {
+			continue
+		}
+
+		switch input[0] {
+		case 'b':
+			n, err := strconv.Atoi(input[1:])
+			if err != nil || n >= len(in.buttons) {
+				return nil, fmt.Errorf("%s: %s: no button %s", m.Name, key, input)
+			}
+			p.Buttons[evdev.CodeName(evdev.EV_KEY, in.buttons[n])] = target
+
+		case 'a':
+			n, err := strconv.Atoi(input[1:])
+			if err != nil || n >= len(in.axes) {
+				return nil, fmt.Errorf("%s: %s: no axis %s", m.Name, key, input)
+			}
+			code := in.axes[n]
+			if strings.HasPrefix(key, "dp") {
+				if sign == 0 {
+					sign = 1
+				}
+				if dpad[code] == nil {
+					dpad[code] = map[int]string{}
+				}
+				dpad[code][sign] = target
+				continue
+			}
+			// Profiles map whole axes, so half axes only drive the d-pad
+			if sign != 0 {
+				return nil, fmt.Errorf("%s: %s: half axis %s is not supported", m.Name, key, m.Fields[key])
+			}
+			// SDL reports Y axes growing downwards, the Switch upwards
+			if key == "lefty" || key == "righty" {
+				invert = !invert
+			}
+			name := evdev.CodeName(evdev.EV_ABS, code)
+			if _, ok := p.Axes[name]; ok {
+				return nil, fmt.Errorf("%s: %s: axis %s is mapped twice", m.Name, key, input)
+			}
+			p.Axes[name] = Axis{Target: target, Invert: invert}
+
+		case 'h':
+			hat, bit, ok := strings.Cut(input[1:], ".")
+			h, herr := strconv.Atoi(hat)
+			b, berr := strconv.Atoi(bit)
+			dir, known := hatDirections[b]
+			if !ok || herr != nil || berr != nil || !known || h >= len(in.hats) {
+				return nil, fmt.Errorf("%s: %s: no hat %s", m.Name, key, input)
+			}
+			code := in.hats[h] + dir.offset
+			if dpad[code] == nil {
+				dpad[code] = map[int]string{}
+			}
+			dpad[code][dir.sign] = target
+		}
+	}
+
+	for code, dirs := range dpad {
+		name := evdev.CodeName(evdev.EV_ABS, code)
+		if _, ok := p.Axes[name]; ok {
+			return nil, fmt.Errorf("%s: %s drives the d-pad and %s", m.Name, name, p.Axes[name].Target)
+		}
+		switch {
+		case (dirs[-1] == "Up" || dirs[-1] == "") && (dirs[1] == "Down" || dirs[1] == ""):
+			p.Axes[name] = Axis{Target: "DpadY"}
+		case (dirs[-1] == "Down" || dirs[-1] == "") && (dirs[1] == "Up" || dirs[1] == ""):
+			p.Axes[name] = Axis{Target: "DpadY", Invert: true}
+		case (dirs[-1] == "Left" || dirs[-1] == "") && (dirs[1] == "Right" || dirs[1] == ""):
+			p.Axes[name] = Axis{Target: "DpadX"}
+		case (dirs[-1] == "Right" || dirs[-1] == "") && (dirs[1] == "Left" || dirs[1] == ""):
+			p.Axes[name] = Axis{Target: "DpadX", Invert: true}
+		default:
+			return nil, fmt.Errorf("%s: %s drives both d-pad axes", m.Name, name)
+		}
+	}
+
+	if _, err := p.NewMapper(nil); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lmLumos/nscon/evdev"
+)
+
+func sdlLine(version uint16, name, fields string) string {
+	id := evdev.ID{BusType: 3, Vendor: 0x045e, Product: 0x028e, Version: version}
+	return fmt.Sprintf("%s,%s,%splatform:Linux,", SDLGUID(id), name, fields)
+}
+
+func TestLookupClosestVersion(t *testing.T) {
+	db, err := LoadSDL(strings.NewReader(strings.Join([]string{
+		sdlLine(0x0100, "v100", ""),
+		sdlLine(0x0120, "v120 first", ""),
+		sdlLine(0x0110, "v110", ""),
+		sdlLine(0x0130, "v130", ""),
+		sdlLine(0x0120, "v120 second", ""),
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		version uint16
+		want    string
+	}{
+		{0x0110, "v110"},
+		{0x0120, "v120 second"},
+		{0x0105, "v100"},
+		{0x0125, "v120 second"},
+		{0x0200, "v130"},
+	} {
+		// Equally close mappings resolve the same way on every lookup
+		for i := 0; i < 20; i++ {
+			m := db.Lookup(evdev.ID{BusType: 3, Vendor: 0x045e, Product: 0x028e, Version: tt.version})
+			if m == nil || m.Name != tt.want {
+				t.Fatalf("version %#x: got %v, want %s", tt.version, m, tt.want)
+			}
+		}
+	}
+	if m := db.Lookup(evdev.ID{BusType: 3, Vendor: 0x045e, Product: 0x028f}); m != nil {
+		t.Errorf("other product: got %s", m.Name)
+	}
+}
+
+func TestProfileHalfAxes(t *testing.T) {
+	caps := evdev.Capabilities{
+		evdev.EV_KEY: {evdev.BTN_SOUTH},
+		evdev.EV_ABS: {evdev.ABS_X, evdev.ABS_Y, evdev.ABS_Z},
+	}
+	for fields, ok := range map[string]bool{
+		"a:b0,leftx:a0,lefty:a1,lefttrigger:a2,":      true,
+		"a:b0,dpup:-a2,dpdown:+a2,":                   true,
+		"a:b0,lefttrigger:-a2,righttrigger:+a2,":      false,
+		"a:b0,lefttrigger:+a2,":                       false,
+		"a:b0,leftx:a0,lefttrigger:a0,":               false,
+		"a:b0,leftx:a0,lefty:a1,dpup:-a1,dpdown:+a1,": false,
+	} {
+		db, err := LoadSDL(strings.NewReader(sdlLine(0x0110, "test", fields)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Lookup(evdev.ID{BusType: 3, Vendor: 0x045e, Product: 0x028e, Version: 0x0110}).Profile(caps)
+		if (err == nil) != ok {
+			t.Errorf("%s: %v", fields, err)
+		}
+	}
+}
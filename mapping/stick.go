@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Stick shapes the deflection of a stick driven by axes, in this order: the
+// deadzone, the outer deadzone, the response curve and the anti-deadzone.
+// They apply to the distance from the center, keeping the direction, or to
+// each axis on its own if Axial is set.
+type Stick struct {
+	// Deadzone is the distance from the center that reads as centered. The
+	// rest of the travel is stretched to keep the full range.
+	Deadzone float64 `json:"deadzone,omitempty"`
+	// OuterDeadzone is the distance from the edge that reads as full deflection
+	OuterDeadzone float64 `json:"outerDeadzone,omitempty"`
+	// AntiDeadzone is the smallest deflection sent once the stick leaves the
+	// deadzone, to skip over the deadzone of the game
+	AntiDeadzone float64 `json:"antiDeadzone,omitempty"`
+	// Axial applies the deadzones and curve to the axes on their own, which
+	// snaps to them at the cost of a square deadzone
+	Axial bool  `json:"axial,omitempty"`
+	Curve Curve `json:"curve,omitempty"`
+}
+
+// Curve maps the deflection after the deadzones, from 0 to 1, onto the
+// deflection sent. The zero Curve is linear.
+type Curve struct {
+	// Exponent raises the deflection to a power, above 1 for finer aim near
+	// the center
+	Exponent float64 `json:"exponent,omitempty"`
+	// Points are pairs of deflections in and out, linearly interpolated
+	// between (0, 0) and (1, 1). They take precedence over Exponent.
+	Points [][2]float64 `json:"points,omitempty"`
+}
+
+// validate checks the ranges of the settings
+func (s Stick) validate() error {
+	for _, v := range []float64{s.Deadzone, s.OuterDeadzone, s.AntiDeadzone} {
+		if v < 0 || v >= 1 {
+			return fmt.Errorf("deadzones must be in [0, 1)")
+		}
+	}
+	if s.Deadzone+s.OuterDeadzone >= 1 {
+		return fmt.Errorf("deadzone and outer deadzone leave no travel")
+	}
+	if s.Curve.Exponent < 0 {
+		return fmt.Errorf("curve exponent must not be negative")
+	}
+	for i, p := range s.Curve.Points {
+		if p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+			return fmt.Errorf("curve points must be in [0, 1]")
+		}
+		if i > 0 && p[0] <= s.Curve.Points[i-1][0] {
+			return fmt.Errorf("curve points must be in increasing order")
+		}
+	}
+	return nil
+}
+
+// Apply shapes the deflection x, y of the stick
+func (s Stick) Apply(x, y float64) (float64, float64) {
+	if s.Axial {
+		return math.Copysign(s.shape(math.Abs(x)), x), math.Copysign(s.shape(math.Abs(y)), y)
+	}
+	r := math.Hypot(x, y)
+	if r == 0 {
+		return 0, 0
+	}
+	// Square gates reach beyond the circle in the corners
+	shaped := s.shape(min(r, 1))
+	return x / r * shaped, y / r * shaped
+}
+
+// shape maps a deflection from 0 to 1
+func (s Stick) shape(v float64) float64 {
+	if v <= s.Deadzone {
+		return 0
+	}
+	v = min((v-s.Deadzone)/(1-s.Deadzone-s.OuterDeadzone), 1)
+	v = s.Curve.apply(v)
+	return s.AntiDeadzone + (1-s.AntiDeadzone)*v
+}
+
+func (c Curve) apply(v float64) float64 {
+	if len(c.Points) > 0 {
+		points := append(append([][2]float64{{0, 0}}, c.Points...), [2]float64{1, 1})
+		// The first point at or right of v, past the implicit (0, 0)
+		i := sort.Search(len(points), func(i int) bool { return points[i][0] >= v })
+		if i == 0 {
+			return points[0][1]
+		}
+		a, b := points[i-1], points[i]
+		if b[0] == a[0] {
+			return b[1]
+		}
+		return a[1] + (b[1]-a[1])*(v-a[0])/(b[0]-a[0])
+	}
+	if c.Exponent > 0 {
+		return math.Pow(v, c.Exponent)
+	}
+	return v
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package mapping
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStickShape(t *testing.T) {
+	tests := []struct {
+		name  string
+		stick Stick
+		// in and out are deflections from 0 to 1
+		in, out []float64
+	}{
+		{
+			name: "linear",
+			in:   []float64{0, 0.5, 1},
+			out:  []float64{0, 0.5, 1},
+		},
+		{
+			name:  "deadzone",
+			stick: Stick{Deadzone: 0.2},
+			in:    []float64{0, 0.2, 0.6, 1},
+			out:   []float64{0, 0, 0.5, 1},
+		},
+		{
+			name:  "outer deadzone",
+			stick: Stick{Deadzone: 0.1, OuterDeadzone: 0.1},
+			in:    []float64{0.1, 0.5, 0.9, 0.95, 1},
+			out:   []float64{0, 0.5, 1, 1, 1},
+		},
+		{
+			name:  "anti-deadzone",
+			stick: Stick{Deadzone: 0.1, AntiDeadzone: 0.2},
+			in:    []float64{0, 0.1, 0.1 + 1e-9, 0.55, 1},
+			out:   []float64{0, 0, 0.2, 0.6, 1},
+		},
+		{
+			name:  "exponent",
+			stick: Stick{Curve: Curve{Exponent: 2}},
+			in:    []float64{0, 0.5, 1},
+			out:   []float64{0, 0.25, 1},
+		},
+		{
+			name:  "exponent after deadzone",
+			stick: Stick{Deadzone: 0.2, Curve: Curve{Exponent: 2}},
+			in:    []float64{0.2, 0.6, 1},
+			out:   []float64{0, 0.25, 1},
+		},
+		{
+			name:  "points",
+			stick: Stick{Curve: Curve{Points: [][2]float64{{0.5, 0.2}}}},
+			in:    []float64{0, 0.25, 0.5, 0.75, 1},
+			out:   []float64{0, 0.1, 0.2, 0.6, 1},
+		},
+		{
+			name:  "points take precedence over exponent",
+			stick: Stick{Curve: Curve{Exponent: 3, Points: [][2]float64{{0.5, 0.2}}}},
+			in:    []float64{0.5},
+			out:   []float64{0.2},
+		},
+		{
+			name:  "anti-deadzone after curve",
+			stick: Stick{AntiDeadzone: 0.3, Curve: Curve{Exponent: 2}},
+			in:    []float64{0, 1e-9, 0.5, 1},
+			out:   []float64{0, 0.3, 0.475, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.stick.validate(); err != nil {
+				t.Fatal(err)
+			}
+			for i, v := range tt.in {
+				if got := tt.stick.shape(v); math.Abs(got-tt.out[i]) > 1e-6 {
+					t.Errorf("shape(%g) = %g, want %g", v, got, tt.out[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStickApply(t *testing.T) {
+	s := Stick{Deadzone: 0.2, AntiDeadzone: 0.1}
+	// Diagonals keep their direction
+	x, y := s.Apply(0.6, -0.6)
+	if math.Abs(x+y) > 1e-9 || x <= 0 {
+		t.Errorf("diagonal: %v, %v", x, y)
+	}
+	// Square gates are clamped to full deflection
+	x, y = s.Apply(1, 1)
+	if r := math.Hypot(x, y); math.Abs(r-1) > 1e-9 {
+		t.Errorf("corner: %v, %v at %v", x, y, r)
+	}
+	// Within the radial deadzone although one axis is beyond it
+	if x, y := s.Apply(0.15, 0.1); x != 0 || y != 0 {
+		t.Errorf("inside the deadzone: %v, %v", x, y)
+	}
+
+	// The axial deadzone only cuts the axis within it
+	s.Axial = true
+	x, y = s.Apply(0.15, -1)
+	if x != 0 || y != -1 {
+		t.Errorf("axial: %v, %v", x, y)
+	}
+}
+
+func TestStickValidate(t *testing.T) {
+	for _, s := range []Stick{
+		{Deadzone: -0.1},
+		{Deadzone: 1},
+		{AntiDeadzone: 1},
+		{Deadzone: 0.5, OuterDeadzone: 0.5},
+		{Curve: Curve{Exponent: -1}},
+		{Curve: Curve{Points: [][2]float64{{0.5, 1.5}}}},
+		{Curve: Curve{Points: [][2]float64{{0.5, 0.2}, {0.5, 0.4}}}},
+	} {
+		if err := s.validate(); err == nil {
+			t.Errorf("%+v: no error", s)
+		}
+	}
+}
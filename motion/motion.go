@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package motion feeds the motion sensors of PlayStation controllers into
+// the IMU of a controller. Linux exposes them as a separate "Motion Sensors"
+// event device next to the gamepad, with the accelerometer on ABS_X, ABS_Y
+// and ABS_Z and the gyroscope on ABS_RX, ABS_RY and ABS_RZ.
+package motion
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+)
+
+// Resolutions used by hid-playstation, for drivers reporting none
+const (
+	DefaultAccelPerG  = 8192
+	DefaultGyroPerDPS = 1024
+)
+
+// The first axes of the accelerometer and gyroscope, three each
+const (
+	accelAxes          = evdev.ABS_X
+	gyroAxes           = evdev.ABS_RX
+	sensorAxesPerGroup = 3
+)
+
+// Find returns the motion sensor device belonging to the same controller as
+// the gamepad device at path, or "" if it has none
+func Find(path string) (string, error) {
+	parent, err := hidDevice(path)
+	if err != nil {
+		return "", err
+	}
+	nodes, err := filepath.Glob("/dev/input/event*")
+	if err != nil {
+		return "", err
+	}
+	for _, node := range nodes {
+		if node == path {
+			continue
+		}
+		if p, err := hidDevice(node); err != nil || p != parent {
+			continue
+		}
+		if isMotionSensor(node) {
+			return node, nil
+		}
+	}
+	return "", nil
+}
+
+// hidDevice returns the sysfs directory of the device the input device at
+// path belongs to, e.g. the HID device of a controller
+func hidDevice(path string) (string, error) {
+	return filepath.EvalSymlinks(filepath.Join("/sys/class/input", filepath.Base(path), "device/device"))
+}
+
+func isMotionSensor(path string) bool {
+	device, err := evdev.Open(path)
+	if err != nil {
+		return false
+	}
+	defer device.Close()
+
+	props, err := device.Properties()
+	return err == nil && slices.Contains(props, evdev.INPUT_PROP_ACCELEROMETER)
+}
+
+// Converter turns the events of a motion sensor device into the IMU fields
+// of ControllerInput
+type Converter struct {
+	// perUnit are the readings of 1 G and 1 degree per second of the axes
+	perUnit [6]float64
+	values  [6]int32
+}
+
+// NewConverter creates a Converter for a motion sensor device whose axes
+// have the ranges infos
+func NewConverter(infos map[uint16]evdev.AbsInfo) *Converter {
+	c := &Converter{}
+	for i := range c.perUnit {
+		code := uint16(accelAxes + i)
+		c.perUnit[i] = DefaultAccelPerG
+		if i >= sensorAxesPerGroup {
+			code = uint16(gyroAxes + i - sensorAxesPerGroup)
+			c.perUnit[i] = DefaultGyroPerDPS
+		}
+		if res := infos[code].Resolution; res > 0 {
+			c.perUnit[i] = float64(res)
+		}
+	}
+	return c
+}
+
+// Apply records e and updates in at the end of each report. It reports
+// whether e belongs to the motion sensors.
+//
+// The sensors use the axes of the input subsystem, X to the right, Y out of
+// the face and Z towards the player, which are turned into those of the
+// Pro Controller.
+func (c *Converter) Apply(in *nscon.ControllerInput, e evdev.Event) bool {
+	switch {
+	case e.Type == evdev.EV_ABS && e.Code >= accelAxes && e.Code < accelAxes+sensorAxesPerGroup:
+		c.values[e.Code-accelAxes] = e.Value
+	case e.Type == evdev.EV_ABS && e.Code >= gyroAxes && e.Code < gyroAxes+sensorAxesPerGroup:
+		c.values[sensorAxesPerGroup+e.Code-gyroAxes] = e.Value
+	case e.Type == evdev.EV_SYN && e.Code == evdev.SYN_REPORT:
+		var v [6]float64
+		for i, value := range c.values {
+			v[i] = float64(value) / c.perUnit[i]
+		}
+		in.IMU.Accel.X, in.IMU.Accel.Y, in.IMU.Accel.Z = -v[2], -v[0], v[1]
+		in.IMU.Gyro.X, in.IMU.Gyro.Y, in.IMU.Gyro.Z = -v[5], -v[3], v[4]
+	default:
+		return false
+	}
+	return true
+}
+
+// Run reads the motion sensor device at path into the IMU of con until ctx
+// is cancelled or the device is gone
+func Run(ctx context.Context, path string, con *nscon.Controller) error {
+	device, err := evdev.Open(path)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+	stop, _ := device.GrabUntil(ctx, false)
+	defer stop()
+
+	infos, err := device.AbsInfos()
+	if err != nil {
+		return err
+	}
+	c := NewConverter(infos)
+	for {
+		e, err := device.ReadEvent()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		c.Apply(&con.Input, e)
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package motion
+
+import (
+	"math"
+	"testing"
+
+	"github.com/lmLumos/nscon"
+	"github.com/lmLumos/nscon/evdev"
+)
+
+func applyAll(t *testing.T, c *Converter, in *nscon.ControllerInput, values map[uint16]int32) {
+	t.Helper()
+	for code, value := range values {
+		if !c.Apply(in, evdev.Event{Type: evdev.EV_ABS, Code: code, Value: value}) {
+			t.Fatalf("%s not applied", evdev.CodeName(evdev.EV_ABS, code))
+		}
+	}
+	c.Apply(in, evdev.Event{Type: evdev.EV_SYN, Code: evdev.SYN_REPORT})
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestConverterAxes(t *testing.T) {
+	c := NewConverter(nil)
+	var in nscon.ControllerInput
+	// Lying flat, face up: gravity along Y of the input subsystem
+	applyAll(t, c, &in, map[uint16]int32{
+		evdev.ABS_X: 0, evdev.ABS_Y: DefaultAccelPerG, evdev.ABS_Z: 0,
+		evdev.ABS_RX: 0, evdev.ABS_RY: 0, evdev.ABS_RZ: 0,
+	})
+	if a := in.IMU.Accel; !near(a.X, 0) || !near(a.Y, 0) || !near(a.Z, 1) {
+		t.Errorf("flat: accel %+v, want Z 1", a)
+	}
+
+	// Each sensor axis at a different reading, to tell them apart
+	applyAll(t, c, &in, map[uint16]int32{
+		evdev.ABS_X: DefaultAccelPerG, evdev.ABS_Y: 2 * DefaultAccelPerG, evdev.ABS_Z: 3 * DefaultAccelPerG,
+		evdev.ABS_RX: 10 * DefaultGyroPerDPS, evdev.ABS_RY: 20 * DefaultGyroPerDPS, evdev.ABS_RZ: 30 * DefaultGyroPerDPS,
+	})
+	if a := in.IMU.Accel; !near(a.X, -3) || !near(a.Y, -1) || !near(a.Z, 2) {
+		t.Errorf("accel %+v, want -3, -1, 2", a)
+	}
+	if g := in.IMU.Gyro; !near(g.X, -30) || !near(g.Y, -10) || !near(g.Z, 20) {
+		t.Errorf("gyro %+v, want -30, -10, 20", g)
+	}
+}
+
+func TestConverterResolution(t *testing.T) {
+	c := NewConverter(map[uint16]evdev.AbsInfo{
+		evdev.ABS_Z:  {Resolution: 100},
+		evdev.ABS_RY: {Resolution: 16},
+	})
+	var in nscon.ControllerInput
+	applyAll(t, c, &in, map[uint16]int32{
+		evdev.ABS_X: DefaultAccelPerG / 2, evdev.ABS_Z: 50,
+		evdev.ABS_RX: DefaultGyroPerDPS, evdev.ABS_RY: 160,
+	})
+	if a := in.IMU.Accel; !near(a.X, -0.5) || !near(a.Y, -0.5) {
+		t.Errorf("accel %+v, want X and Y -0.5", a)
+	}
+	if g := in.IMU.Gyro; !near(g.Y, -1) || !near(g.Z, 10) {
+		t.Errorf("gyro %+v, want Y -1 and Z 10", g)
+	}
+}
+
+func TestConverterWaitsForReport(t *testing.T) {
+	c := NewConverter(nil)
+	var in nscon.ControllerInput
+	c.Apply(&in, evdev.Event{Type: evdev.EV_ABS, Code: evdev.ABS_RY, Value: DefaultGyroPerDPS})
+	if in.IMU.Gyro.Z != 0 {
+		t.Errorf("gyro %+v before SYN_REPORT", in.IMU.Gyro)
+	}
+	if c.Apply(&in, evdev.Event{Type: evdev.EV_KEY, Code: evdev.BTN_SOUTH, Value: 1}) {
+		t.Error("applied a button")
+	}
+	if c.Apply(&in, evdev.Event{Type: evdev.EV_ABS, Code: evdev.ABS_HAT0X, Value: 1}) {
+		t.Error("applied a hat")
+	}
+	c.Apply(&in, evdev.Event{Type: evdev.EV_SYN, Code: evdev.SYN_REPORT})
+	if !near(in.IMU.Gyro.Z, 1) {
+		t.Errorf("gyro %+v after SYN_REPORT, want Z 1", in.IMU.Gyro)
+	}
+}
@@ -3,11 +3,18 @@
 package nscon
 
 import (
+	"encoding/binary"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"math"
 	"os"
+	"slices"
+	"sync"
 	"time"
+
+	"github.com/lmLumos/nscon/hid"
 )
 
 var SPI_ROM_DATA = map[byte][]byte{
@@ -32,6 +39,13 @@ var SPI_ROM_DATA = map[byte][]byte{
 	},
 }
 
+// maxSPIRead is the most bytes of SPI flash the controller returns in one
+// read, which keeps the reply within a report
+const maxSPIRead = 0x1d
+
+// reportLength is the length of the reports exchanged with the host, including the report ID
+var reportLength = hid.ProController.ReportLength()
+
 type ControllerInput struct {
 	Dpad struct {
 		Up, Down, Left, Right uint8
@@ -46,44 +60,89 @@ type ControllerInput struct {
 			Press uint8
 		}
 	}
+	// IMU is the motion sent in the input reports once the host enables it,
+	// in the axes of the controller: X out of its top, Y to its left and Z
+	// out of its face. Gyro rates turn around them by the right-hand rule.
+	IMU struct {
+		// Accel is the acceleration in G, Z is 1 for a controller lying flat
+		Accel struct{ X, Y, Z float64 }
+		// Gyro is the angular velocity in degrees per second
+		Gyro struct{ X, Y, Z float64 }
+	}
 }
 
+// Scales of the IMU samples, matching the calibration in SPI_ROM_DATA
+const (
+	// AccelPerG is the accelerometer reading of 1 G, for a range of ±8 G
+	AccelPerG = 4096
+	// GyroPerDPS is the gyroscope reading of 1 degree per second, for a range of ±2000 °/s
+	GyroPerDPS = 15335.0 / 936
+)
+
 type Controller struct {
 	path            string
-	fp              *os.File
+	fp              io.ReadWriteCloser
 	count           uint8
 	stopCounter     chan struct{}
 	stopInput       chan struct{}
 	stopCommunicate chan struct{}
+	closed          chan struct{}
+	lost            chan struct{}
+	events          chan Event
+	state           ConnectionState
+	stateChanged    chan struct{}
+	imu             bool
+	mu              sync.Mutex
+	log             *slog.Logger
 	Input           ControllerInput
-	LogLevel        int
+	Logger          *slog.Logger
+	Reconnect       ReconnectPolicy
+	Tap             Tap
+	// OpenDevice opens the transport to the host, e.g. an in-memory pipe
+	// for tests. Nil opens the device path as a file.
+	OpenDevice func(path string) (io.ReadWriteCloser, error)
 }
 
 // NewController creates an instance of Controller with device path
 func NewController(path string) *Controller {
 	return &Controller{
-		path: path,
+		path:   path,
+		events: make(chan Event, 16),
+	}
+}
+
+// logger returns Logger, or the default logger if unset, annotated with the device path
+func (c *Controller) logger() *slog.Logger {
+	l := c.Logger
+	if l == nil {
+		l = slog.Default()
 	}
+	return l.With("path", c.path)
 }
 
 // Close closes all channel and device file
 func (c *Controller) Close() {
-	if c.fp == nil {
-		if c.LogLevel > 0 {
-			log.Println("Already closed.")
-		}
+	c.mu.Lock()
+	if c.closed == nil {
+		c.mu.Unlock()
+		c.logger().Debug("already closed")
 		return
 	}
+	close(c.closed)
 	close(c.stopCounter)
-	close(c.stopInput)
-	close(c.stopCommunicate)
-	// TODO: Send close magic packet
-	c.fp.Close()
-	c.fp = nil
+	changed := c.stopSession()
+	c.closed = nil
+	changed = c.setState(Disconnected) || changed
+	c.mu.Unlock()
+
+	if changed {
+		c.emit(Event{Kind: EventStateChanged, State: Disconnected})
+	}
 }
 
 func (c *Controller) startCounter() {
 	ticker := time.NewTicker(time.Millisecond * 5)
+	stop := c.stopCounter
 
 	go func() {
 		defer ticker.Stop()
@@ -91,7 +150,7 @@ func (c *Controller) startCounter() {
 			select {
 			case <-ticker.C:
 				c.count++
-			case <-c.stopCounter:
+			case <-stop:
 				return
 			}
 		}
@@ -147,7 +206,60 @@ func (c *Controller) getInputBuffer() []byte {
 		leftStick[2], rightStick[0], rightStick[1], rightStick[2], 0x00}
 }
 
+// imuCalibration returns the accelerometer and gyroscope offsets of the
+// user calibration in the SPI ROM, which the host subtracts from the samples
+func imuCalibration() (accel, gyro [3]int16) {
+	cal := SPI_ROM_DATA[0x80][0x28:]
+	for i := range accel {
+		accel[i] = int16(binary.LittleEndian.Uint16(cal[2*i:]))
+		gyro[i] = int16(binary.LittleEndian.Uint16(cal[12+2*i:]))
+	}
+	return accel, gyro
+}
+
+// imuSample converts v into a sample with scale and offset, saturating at
+// the limits of int16
+func imuSample(v, scale float64, offset int16) uint16 {
+	return uint16(int16(max(math.MinInt16, min(math.Round(v*scale)+float64(offset), math.MaxInt16))))
+}
+
+// getIMUBuffer returns the three IMU samples of a standard input report,
+// zeros while the host hasn't enabled the IMU
+func (c *Controller) getIMUBuffer() []byte {
+	buf := make([]byte, 36)
+	c.mu.Lock()
+	enabled := c.imu
+	c.mu.Unlock()
+	if !enabled {
+		return buf
+	}
+
+	accelOffset, gyroOffset := imuCalibration()
+	accel, gyro := c.Input.IMU.Accel, c.Input.IMU.Gyro
+	sample := make([]byte, 12)
+	for i, v := range []float64{accel.X, accel.Y, accel.Z} {
+		binary.LittleEndian.PutUint16(sample[2*i:], imuSample(v, AccelPerG, accelOffset[i]))
+	}
+	for i, v := range []float64{gyro.X, gyro.Y, gyro.Z} {
+		binary.LittleEndian.PutUint16(sample[6+2*i:], imuSample(v, GyroPerDPS, gyroOffset[i]))
+	}
+	// The report carries three samples 5ms apart, all the same here
+	for i := 0; i < 3; i++ {
+		copy(buf[12*i:], sample)
+	}
+	return buf
+}
+
 func (c *Controller) startInputReport() {
+	c.mu.Lock()
+	stop := c.stopInput
+	streaming := c.state == Streaming
+	c.mu.Unlock()
+	if stop == nil || streaming {
+		return
+	}
+	c.transition(Streaming)
+
 	ticker := time.NewTicker(time.Millisecond * 30)
 
 	go func() {
@@ -155,14 +267,27 @@ func (c *Controller) startInputReport() {
 		for {
 			select {
 			case <-ticker.C:
-				c.write(0x30, c.count, c.getInputBuffer())
-			case <-c.stopInput:
+				c.write(0x30, c.count, append(c.getInputBuffer(), c.getIMUBuffer()...))
+			case <-stop:
 				return
 			}
 		}
 	}()
 }
 
+func (c *Controller) stopInputReport() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopInput == nil {
+		return
+	}
+	close(c.stopInput)
+	c.stopInput = make(chan struct{})
+	if c.setState(Suspended) {
+		c.emit(Event{Kind: EventStateChanged, State: Suspended})
+	}
+}
+
 func (c *Controller) uart(ack bool, subCmd byte, data []byte) {
 	ackByte := byte(0x00)
 	if ack {
@@ -171,103 +296,238 @@ func (c *Controller) uart(ack bool, subCmd byte, data []byte) {
 			ackByte |= subCmd
 		}
 	}
-	c.write(0x21, c.count, append(append(c.getInputBuffer(), []byte{ackByte, subCmd}...), data...))
+	if err := c.write(0x21, c.count, append(append(c.getInputBuffer(), []byte{ackByte, subCmd}...), data...)); err != nil {
+		c.log.Warn("dropped UART reply", "subcmd", subCmd, "err", err)
+	}
 }
 
-func (c *Controller) write(ack byte, cmd byte, buf []byte) {
-	data := append(append([]byte{ack, cmd}, buf...), make([]byte, 62-len(buf))...)
-	c.fp.Write(data)
+func (c *Controller) write(ack byte, cmd byte, buf []byte) error {
+	if len(buf) > reportLength-2 {
+		return fmt.Errorf("report %#x of %d bytes exceeds %d", ack, len(buf)+2, reportLength)
+	}
+	data := append(append([]byte{ack, cmd}, buf...), make([]byte, reportLength-2-len(buf))...)
+	c.mu.Lock()
+	fp := c.fp
+	c.mu.Unlock()
+	if fp == nil {
+		return nil
+	}
+	if c.Tap != nil {
+		c.Tap.Report(DirIn, time.Now(), data)
+	}
+	if ack != 0x30 {
+		c.log.Debug("report", "dir", DirIn, "id", ack, "len", len(data))
+	}
+	if _, err := fp.Write(data); err != nil {
+		c.detach(fp, err)
+	}
+	return nil
 }
 
 // Connect begins connection to device
 func (c *Controller) Connect() error {
-	var err error
-	if c.fp != nil {
+	c.mu.Lock()
+	if c.closed != nil {
+		c.mu.Unlock()
 		return errors.New("Already connected.")
 	}
+	closed := make(chan struct{})
+	c.closed = closed
+	lost := make(chan struct{}, 1)
+	c.lost = lost
+	c.stopCounter = make(chan struct{})
+	c.log = c.logger()
+	c.mu.Unlock()
+
+	if err := c.open(closed); err != nil {
+		c.mu.Lock()
+		close(c.stopCounter)
+		c.closed = nil
+		c.mu.Unlock()
+		return err
+	}
+
+	c.startCounter()
+
+	if c.Reconnect.Enabled {
+		go c.supervise(closed, lost)
+		if c.Reconnect.UDCState != "" {
+			go c.watchUDC(closed)
+		}
+	}
+
+	return nil
+}
+
+func openFile(path string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_SYNC, os.ModeDevice)
+}
 
-	c.fp, err = os.OpenFile(c.path, os.O_RDWR|os.O_SYNC, os.ModeDevice)
+// open opens the device file and starts a new session on it, unless the
+// connection closed stands for was closed since, even if Connect opened
+// another one
+func (c *Controller) open(closed chan struct{}) error {
+	openDevice := c.OpenDevice
+	if openDevice == nil {
+		openDevice = openFile
+	}
+	fp, err := openDevice(c.path)
 	if err != nil {
 		return err
 	}
 
-	c.stopCounter = make(chan struct{})
+	c.mu.Lock()
+	if c.closed != closed {
+		c.mu.Unlock()
+		fp.Close()
+		return errors.New("Already closed.")
+	}
+	c.fp = fp
 	c.stopInput = make(chan struct{})
 	c.stopCommunicate = make(chan struct{})
-
-	c.startCounter()
+	stop := c.stopCommunicate
+	c.mu.Unlock()
+	c.transition(Handshaking)
 
 	// Reset magic packet
 	c.write(0x81, 0x03, []byte{})
 	c.write(0x81, 0x01, []byte{0x00, 0x03})
 
-	go func() {
-		buf := make([]byte, 128)
+	go c.communicate(fp, stop)
 
-		for {
-			select {
-			case <-c.stopCommunicate:
-				return
-			default:
-			}
+	return nil
+}
 
-			switch buf[0] {
-			case 0x80:
-				switch buf[1] {
-				case 0x01:
-					c.write(0x81, buf[1], []byte{0x00, 0x03, 0x00, 0x00, 0x5e, 0x00, 0x53, 0x5e})
-				case 0x02, 0x03:
-					c.write(0x81, buf[1], []byte{})
-				case 0x04:
-					c.startInputReport()
-				case 0x05:
-					close(c.stopInput)
-					c.stopInput = make(chan struct{})
-				}
+// stopSession stops the goroutines of the current session and closes the
+// device file. It reports whether the state changed to Disconnected. c.mu
+// must be held.
+func (c *Controller) stopSession() bool {
+	if c.fp == nil {
+		return false
+	}
+	close(c.stopInput)
+	close(c.stopCommunicate)
+	// TODO: Send close magic packet
+	c.fp.Close()
+	c.fp = nil
+	c.stopInput = nil
+	c.stopCommunicate = nil
+	// The next host enables the IMU again if it wants it
+	c.imu = false
+	return c.setState(Disconnected)
+}
+
+// detach tears down the session using fp after an I/O error and hands over
+// to the reconnect supervisor
+func (c *Controller) detach(fp io.ReadWriteCloser, err error) {
+	c.mu.Lock()
+	if fp == nil || c.fp != fp {
+		c.mu.Unlock()
+		return
+	}
+	changed := c.stopSession()
+	lost := c.lost
+	c.mu.Unlock()
+
+	c.log.Warn("host detached", "err", err)
+	c.emit(Event{Kind: EventDetached, Err: err})
+	if changed {
+		c.emit(Event{Kind: EventStateChanged, State: Disconnected})
+	}
+
+	select {
+	case lost <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Controller) communicate(fp io.ReadWriteCloser, stop chan struct{}) {
+	buf := make([]byte, 128)
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		n, err := fp.Read(buf)
+		if err != nil {
+			c.detach(fp, err)
+			return
+		}
+		// Don't let a short report read the bytes of an earlier one
+		clear(buf[n:])
+		if c.Tap != nil {
+			c.Tap.Report(DirOut, time.Now(), buf[:n])
+		}
+		c.log.Debug("report", "dir", DirOut, "id", buf[0], "len", n)
+
+		switch buf[0] {
+		case 0x80:
+			switch buf[1] {
 			case 0x01:
-				switch buf[10] {
-				case 0x01: // Bluetooth manual pairing
-					c.uart(true, buf[10], []byte{0x03, 0x01})
-				case 0x02: // Request device info
-					c.uart(true, buf[10], []byte{0x03, 0x48, 0x03,
-						0x02, 0x5e, 0x53, 0x00, 0x5e, 0x00, 0x00, 0x03, 0x01})
-				case 0x03, 0x08, 0x30, 0x38, 0x40, 0x41, 0x48: // Empty response
-					c.uart(true, buf[10], []byte{})
-				case 0x04: // Empty response
-					c.uart(true, buf[10], []byte{})
-				case 0x10: // Read SPI ROM
-					data, ok := SPI_ROM_DATA[buf[12]]
-					if ok {
-						c.uart(true, buf[10], append(buf[11:16],
-							data[buf[11]:buf[11]+buf[15]]...))
-						if c.LogLevel > 1 {
-							log.Printf("Read SPI address: %02x%02x[%d] %v\n",
-								buf[12], buf[11], buf[15], data[buf[11]:buf[11]+buf[15]])
-						}
-					} else {
-						c.uart(false, buf[10], []byte{})
-						if c.LogLevel > 1 {
-							log.Printf("Unknown SPI address: %02x[%d]\n", buf[12], buf[15])
-						}
-					}
-				case 0x21:
-					// FIXME: Check ack value
-					c.uart(true, buf[10], []byte{0x01, 0x00, 0xff, 0x00, 0x03, 0x00, 0x05, 0x01})
-				default:
-					if c.LogLevel > 1 {
-						log.Println("UART unknown request", buf[10], buf)
-					}
+				c.write(0x81, buf[1], []byte{0x00, 0x03, 0x00, 0x00, 0x5e, 0x00, 0x53, 0x5e})
+			case 0x02:
+				c.write(0x81, buf[1], []byte{})
+				c.transition(Paired)
+			case 0x03:
+				c.write(0x81, buf[1], []byte{})
+			case 0x04:
+				c.startInputReport()
+			case 0x05:
+				c.stopInputReport()
+			}
+		case 0x01:
+			if n < 11 {
+				c.log.Debug("short UART request", "len", n)
+				break
+			}
+			switch buf[10] {
+			case 0x01: // Bluetooth manual pairing
+				c.uart(true, buf[10], []byte{0x03, 0x01})
+			case 0x02: // Request device info
+				c.uart(true, buf[10], []byte{0x03, 0x48, 0x03,
+					0x02, 0x5e, 0x53, 0x00, 0x5e, 0x00, 0x00, 0x03, 0x01})
+			case 0x03, 0x08, 0x30, 0x38, 0x41, 0x48: // Empty response
+				c.uart(true, buf[10], []byte{})
+			case 0x40: // Enable IMU
+				c.mu.Lock()
+				c.imu = buf[11] != 0
+				c.mu.Unlock()
+				c.uart(true, buf[10], []byte{})
+			case 0x04: // Empty response
+				c.uart(true, buf[10], []byte{})
+			case 0x10: // Read SPI ROM
+				// The host picks the range, so reject reads past the page or
+				// longer than the controller sends
+				if n < 16 {
+					c.log.Debug("short SPI read", "subcmd", buf[10], "len", n)
+					c.uart(false, buf[10], []byte{})
+					break
 				}
-
-			case 0x00:
-			case 0x10:
-			default:
-				if c.LogLevel > 1 {
-					log.Println("unknown request", buf[0])
+				addr := uint16(buf[12])<<8 | uint16(buf[11])
+				off, length := int(buf[11]), int(buf[15])
+				data, ok := SPI_ROM_DATA[buf[12]]
+				if !ok || off+length > len(data) || length > maxSPIRead {
+					c.log.Debug("unknown SPI address", "subcmd", buf[10], "addr", addr, "len", length)
+					c.uart(false, buf[10], []byte{})
+					break
 				}
+				chunk := data[off : off+length]
+				c.log.Debug("read SPI", "subcmd", buf[10], "addr", addr, "len", length, "data", chunk)
+				c.uart(true, buf[10], append(slices.Clone(buf[11:16]), chunk...))
+			case 0x21:
+				// FIXME: Check ack value
+				c.uart(true, buf[10], []byte{0x01, 0x00, 0xff, 0x00, 0x03, 0x00, 0x05, 0x01})
+			default:
+				c.log.Debug("unknown UART request", "subcmd", buf[10], "data", buf)
 			}
-		}
-	}()
 
-	return nil
+		case 0x00:
+		case 0x10:
+		default:
+			c.log.Debug("unknown request", "id", buf[0])
+		}
+	}
 }
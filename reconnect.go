@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package nscon
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// ReconnectPolicy describes how a Controller recovers after the host detaches
+type ReconnectPolicy struct {
+	// Enabled turns on automatic reconnection
+	Enabled bool
+	// MaxAttempts limits the number of reopen attempts per detach, 0 means unlimited
+	MaxAttempts int
+	// Backoff is the delay before the first attempt, doubled after every failure
+	Backoff time.Duration
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+	// UDCState is the sysfs state attribute of the UDC the gadget is bound to,
+	// e.g. /sys/class/udc/fe980000.usb/state. When set, it is polled to detect
+	// cable pulls and to wait for the host before reopening the device.
+	UDCState string
+	// PollInterval is the polling interval of UDCState
+	PollInterval time.Duration
+}
+
+// DefaultReconnectPolicy retries forever with a backoff from 500ms up to 10s
+var DefaultReconnectPolicy = ReconnectPolicy{
+	Enabled:      true,
+	Backoff:      500 * time.Millisecond,
+	MaxBackoff:   10 * time.Second,
+	PollInterval: time.Second,
+}
+
+// EventKind identifies the type of an Event
+type EventKind int
+
+const (
+	// EventDetached is emitted when the host is gone, Err holds the cause
+	EventDetached EventKind = iota
+	// EventReconnecting is emitted before each reopen attempt
+	EventReconnecting
+	// EventReconnected is emitted when the device was reopened
+	EventReconnected
+	// EventReconnectFailed is emitted when MaxAttempts is exhausted
+	EventReconnectFailed
+	// EventStateChanged is emitted when the ConnectionState changes, State holds the new state
+	EventStateChanged
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventDetached:
+		return "detached"
+	case EventReconnecting:
+		return "reconnecting"
+	case EventReconnected:
+		return "reconnected"
+	case EventReconnectFailed:
+		return "reconnect failed"
+	case EventStateChanged:
+		return "state changed"
+	}
+	return "unknown"
+}
+
+// Event notifies a change in the connection of a Controller
+type Event struct {
+	Kind    EventKind
+	Time    time.Time
+	Attempt int
+	Err     error
+	State   ConnectionState
+}
+
+var errNotAttached = errors.New("UDC not attached")
+
+// Events returns the channel on which connection events are delivered.
+// Events are dropped when the channel is full.
+func (c *Controller) Events() <-chan Event {
+	return c.events
+}
+
+func (c *Controller) emit(ev Event) {
+	ev.Time = time.Now()
+	select {
+	case c.events <- ev:
+	default:
+	}
+}
+
+func (c *Controller) supervise(closed, lost chan struct{}) {
+	for {
+		select {
+		case <-closed:
+			return
+		case <-lost:
+		}
+		c.reconnect(closed)
+	}
+}
+
+func (c *Controller) reconnect(closed chan struct{}) {
+	backoff := c.Reconnect.Backoff
+	if backoff <= 0 {
+		backoff = DefaultReconnectPolicy.Backoff
+	}
+	maxBackoff := c.Reconnect.MaxBackoff
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+
+	var err error
+	for attempt := 1; c.Reconnect.MaxAttempts == 0 || attempt <= c.Reconnect.MaxAttempts; attempt++ {
+		c.emit(Event{Kind: EventReconnecting, Attempt: attempt})
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-closed:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if c.Reconnect.UDCState != "" && !udcAttached(c.Reconnect.UDCState) {
+			err = errNotAttached
+		} else {
+			err = c.open(closed)
+		}
+		if err == nil {
+			c.log.Info("reconnected", "attempt", attempt)
+			c.emit(Event{Kind: EventReconnected, Attempt: attempt})
+			return
+		}
+		c.log.Debug("reconnect failed", "attempt", attempt, "err", err)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	c.log.Error("giving up reconnection", "err", err)
+	c.emit(Event{Kind: EventReconnectFailed, Err: err})
+}
+
+// watchUDC detaches the current session when the UDC leaves the attached state
+func (c *Controller) watchUDC(closed chan struct{}) {
+	interval := c.Reconnect.PollInterval
+	if interval <= 0 {
+		interval = DefaultReconnectPolicy.PollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	attached := udcAttached(c.Reconnect.UDCState)
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		now := udcAttached(c.Reconnect.UDCState)
+		if attached && !now {
+			c.mu.Lock()
+			fp := c.fp
+			c.mu.Unlock()
+			c.detach(fp, errNotAttached)
+		}
+		attached = now
+	}
+}
+
+// udcAttached reports whether the UDC state attribute at path says a host is present
+func udcAttached(path string) bool {
+	state, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(state)) != "not attached"
+}
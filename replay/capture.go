@@ -0,0 +1,287 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+// Package replay feeds captured host traffic into a Controller and compares
+// its replies with those of the captured device.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lmLumos/nscon"
+)
+
+// Packet is a single report of a capture
+type Packet struct {
+	Dir  nscon.Direction
+	Time time.Time
+	Data []byte
+}
+
+// Link types of USB captures
+const (
+	linkTypeUSBLinux        = 189
+	linkTypeUSBPcap         = 249
+	linkTypeUSBLinuxMmapped = 220
+)
+
+// Load reads a pcap, pcapng or JSON lines capture and returns the interrupt
+// reports it contains, in order
+func Load(r io.Reader) ([]Packet, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte{0x0a, 0x0d, 0x0d, 0x0a}):
+		return loadPcapng(br)
+	case binary.LittleEndian.Uint32(magic) == 0xa1b2c3d4,
+		binary.BigEndian.Uint32(magic) == 0xa1b2c3d4,
+		binary.LittleEndian.Uint32(magic) == 0xa1b23c4d,
+		binary.BigEndian.Uint32(magic) == 0xa1b23c4d:
+		return loadPcap(br)
+	}
+	return loadJSONL(br)
+}
+
+func loadPcap(r io.Reader) ([]Packet, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if binary.BigEndian.Uint32(hdr) == 0xa1b2c3d4 || binary.BigEndian.Uint32(hdr) == 0xa1b23c4d {
+		order = binary.BigEndian
+	}
+	nano := order.Uint32(hdr) == 0xa1b23c4d
+	linkType := order.Uint32(hdr[20:]) & 0x0fffffff
+
+	var packets []Packet
+	rec := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, rec); err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return nil, err
+		}
+		data := make([]byte, order.Uint32(rec[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		frac := time.Duration(order.Uint32(rec[4:])) * time.Microsecond
+		if nano {
+			frac = time.Duration(order.Uint32(rec[4:]))
+		}
+		t := time.Unix(int64(order.Uint32(rec[0:])), 0).Add(frac)
+
+		if p, ok := decodeUSB(linkType, t, data); ok {
+			packets = append(packets, p)
+		}
+	}
+}
+
+func loadPcapng(r io.Reader) ([]Packet, error) {
+	var (
+		order     binary.ByteOrder = binary.LittleEndian
+		linkTypes []uint32
+		packets   []Packet
+	)
+
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, hdr); err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		blockType := order.Uint32(hdr)
+		if blockType == 0x0a0d0d0a {
+			// Byte order of a section is given by its header
+			magic := make([]byte, 4)
+			if _, err := io.ReadFull(r, magic); err != nil {
+				return nil, err
+			}
+			order = binary.LittleEndian
+			if binary.BigEndian.Uint32(magic) == 0x1a2b3c4d {
+				order = binary.BigEndian
+			}
+			linkTypes = nil
+			if _, err := io.CopyN(io.Discard, r, int64(order.Uint32(hdr[4:]))-12); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		total := order.Uint32(hdr[4:])
+		if total < 12 {
+			return nil, fmt.Errorf("invalid pcapng block length %d", total)
+		}
+		body := make([]byte, total-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		body = body[:len(body)-4]
+
+		switch blockType {
+		case 0x00000001: // Interface description
+			if len(body) < 2 {
+				return nil, errors.New("truncated pcapng interface description")
+			}
+			linkTypes = append(linkTypes, uint32(order.Uint16(body)))
+		case 0x00000006: // Enhanced packet
+			if len(body) < 20 || int(order.Uint32(body[12:])) > len(body)-20 {
+				return nil, errors.New("truncated pcapng packet")
+			}
+			iface := order.Uint32(body)
+			if int(iface) >= len(linkTypes) {
+				return nil, fmt.Errorf("packet for unknown interface %d", iface)
+			}
+			// Timestamps are assumed to have the default microsecond resolution
+			ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			t := time.UnixMicro(int64(ts))
+			data := body[20 : 20+order.Uint32(body[12:])]
+			if p, ok := decodeUSB(linkTypes[iface], t, data); ok {
+				packets = append(packets, p)
+			}
+		case 0x00000003: // Simple packet
+			if len(body) < 4 {
+				return nil, errors.New("truncated pcapng packet")
+			}
+			if len(linkTypes) == 0 {
+				return nil, errors.New("packet before interface description")
+			}
+			if p, ok := decodeUSB(linkTypes[0], time.Time{}, body[4:]); ok {
+				packets = append(packets, p)
+			}
+		}
+	}
+}
+
+// decodeUSB extracts the payload of an interrupt transfer carrying data
+func decodeUSB(linkType uint32, t time.Time, data []byte) (Packet, bool) {
+	switch linkType {
+	case linkTypeUSBLinux, linkTypeUSBLinuxMmapped:
+		hdrLen := 48
+		if linkType == linkTypeUSBLinuxMmapped {
+			hdrLen = 64
+		}
+		if len(data) < hdrLen || data[9] != 1 || data[15] != 0 {
+			return Packet{}, false
+		}
+		in := data[10]&0x80 != 0
+		// IN data arrives on completion, OUT data on submission
+		if (in && data[8] != 'C') || (!in && data[8] != 'S') {
+			return Packet{}, false
+		}
+		return newPacket(in, t, data[hdrLen:])
+
+	case linkTypeUSBPcap:
+		if len(data) < 27 {
+			return Packet{}, false
+		}
+		hdrLen := int(binary.LittleEndian.Uint16(data))
+		if len(data) < hdrLen || data[22] != 1 {
+			return Packet{}, false
+		}
+		in := data[21]&0x80 != 0
+		completion := data[16]&0x01 != 0
+		if in != completion {
+			return Packet{}, false
+		}
+		return newPacket(in, t, data[hdrLen:])
+	}
+	return Packet{}, false
+}
+
+func newPacket(in bool, t time.Time, data []byte) (Packet, bool) {
+	if len(data) == 0 {
+		return Packet{}, false
+	}
+	dir := nscon.DirOut
+	if in {
+		dir = nscon.DirIn
+	}
+	return Packet{Dir: dir, Time: t, Data: append([]byte(nil), data...)}, true
+}
+
+type jsonPacket struct {
+	Time time.Time `json:"time"`
+	Dir  string    `json:"dir"`
+	Data string    `json:"data"`
+}
+
+func loadJSONL(r io.Reader) ([]Packet, error) {
+	var packets []Packet
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var jp jsonPacket
+		if err := json.Unmarshal([]byte(text), &jp); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		data, err := hex.DecodeString(strings.ReplaceAll(jp.Data, " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		var dir nscon.Direction
+		switch jp.Dir {
+		case "in":
+			dir = nscon.DirIn
+		case "out":
+			dir = nscon.DirOut
+		default:
+			return nil, fmt.Errorf("line %d: unknown direction %q", line, jp.Dir)
+		}
+		packets = append(packets, Packet{Dir: dir, Time: jp.Time, Data: data})
+	}
+	return packets, scanner.Err()
+}
+
+// JSONLWriter is a nscon.Tap which records reports in the JSON lines format
+// accepted by Load
+type JSONLWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewJSONLWriter returns a JSONLWriter writing to w
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	return &JSONLWriter{enc: json.NewEncoder(w)}
+}
+
+// Report implements nscon.Tap
+func (j *JSONLWriter) Report(dir nscon.Direction, t time.Time, data []byte) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return
+	}
+	j.err = j.enc.Encode(jsonPacket{Time: t, Dir: dir.String(), Data: hex.EncodeToString(data)})
+}
+
+// Err returns the first write error, after which reports are discarded
+func (j *JSONLWriter) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
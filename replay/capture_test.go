@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package replay
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lmLumos/nscon"
+)
+
+func TestPcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := nscon.NewPcapngWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Packet{
+		{Dir: nscon.DirOut, Time: time.UnixMicro(1700000000123456), Data: []byte{0x80, 0x01}},
+		{Dir: nscon.DirIn, Time: time.UnixMicro(1700000000124000), Data: []byte{0x81, 0x01, 0x00, 0x03, 0x00, 0x00, 0x5e, 0x00, 0x53, 0x5e}},
+		// Odd lengths need padding to the 32-bit block boundary
+		{Dir: nscon.DirIn, Time: time.UnixMicro(1700000001000000), Data: []byte{0x30, 0x01, 0x91}},
+	}
+	for _, p := range want {
+		w.Report(p.Dir, p.Time, p.Data)
+	}
+
+	got, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Dir != want[i].Dir || !got[i].Time.Equal(want[i].Time) || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Errorf("packet %d: got %v %v % x, want %v %v % x", i,
+				got[i].Dir, got[i].Time, got[i].Data, want[i].Dir, want[i].Time, want[i].Data)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lmLumos/nscon"
+)
+
+// Options tunes a replay
+type Options struct {
+	// Timeout is how long to wait for the controller to process one host report
+	Timeout time.Duration
+}
+
+// Mismatch is a reply of the controller which differs from the capture
+type Mismatch struct {
+	// Step is the index of the host report in the capture, -1 before the first one
+	Step     int
+	Request  []byte
+	Expected []byte // nil when the controller sent an extra reply
+	Actual   []byte // nil when the controller did not reply
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("step %d request % x\n  expected % x\n  actual   % x",
+		m.Step, m.Request, m.Expected, m.Actual)
+}
+
+// Run connects c over an in-memory transport, feeds it the host reports of
+// packets in order and compares its replies with the captured device replies.
+// Standard input reports (0x30) are ignored, and the timer and input fields
+// of 0x21 and 0x30 reports are masked.
+func Run(c *nscon.Controller, packets []Packet, opts Options) ([]Mismatch, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+
+	p := newPipe()
+	c.OpenDevice = func(string) (io.ReadWriteCloser, error) {
+		return p, nil
+	}
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var (
+		mismatches []Mismatch
+		request    []byte
+		expected   [][]byte
+		step       = -1
+	)
+
+	check := func() error {
+		select {
+		case <-p.idle:
+		case <-time.After(opts.Timeout):
+			return fmt.Errorf("step %d: controller did not process request % x", step, request)
+		}
+
+		actual := p.replies()
+		for i := 0; i < len(expected) || i < len(actual); i++ {
+			m := Mismatch{Step: step, Request: request}
+			if i < len(expected) {
+				m.Expected = expected[i]
+			}
+			if i < len(actual) {
+				m.Actual = actual[i]
+			}
+			if m.Expected == nil || m.Actual == nil || !bytes.Equal(mask(m.Expected), mask(m.Actual)) {
+				mismatches = append(mismatches, m)
+			}
+		}
+		return nil
+	}
+
+	for _, pkt := range packets {
+		if pkt.Dir == nscon.DirIn {
+			if !isInputReport(pkt.Data) {
+				expected = append(expected, pkt.Data)
+			}
+			continue
+		}
+
+		if err := check(); err != nil {
+			return mismatches, err
+		}
+		step++
+		request = pkt.Data
+		expected = nil
+		if err := p.send(request); err != nil {
+			return mismatches, err
+		}
+	}
+
+	return mismatches, check()
+}
+
+func isInputReport(data []byte) bool {
+	return len(data) > 0 && data[0] == 0x30
+}
+
+// mask clears the timer and input fields which depend on timing and user input
+func mask(data []byte) []byte {
+	data = append([]byte(nil), data...)
+	if len(data) > 0 && (data[0] == 0x21 || data[0] == 0x30) {
+		for i := 1; i <= 12 && i < len(data); i++ {
+			data[i] = 0
+		}
+	}
+	return data
+}
+
+// pipe is an in-memory transport standing in for the hidg device
+type pipe struct {
+	out    chan []byte // Host to controller
+	in     chan []byte // Controller to host
+	idle   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipe() *pipe {
+	return &pipe{
+		out:    make(chan []byte),
+		in:     make(chan []byte, 1024),
+		idle:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// Read signals that the controller is waiting for the next host report
+func (p *pipe) Read(b []byte) (int, error) {
+	select {
+	case p.idle <- struct{}{}:
+	default:
+	}
+	select {
+	case data := <-p.out:
+		return copy(b, data), nil
+	case <-p.closed:
+		return 0, io.EOF
+	}
+}
+
+func (p *pipe) Write(b []byte) (int, error) {
+	select {
+	case p.in <- append([]byte(nil), b...):
+		return len(b), nil
+	case <-p.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (p *pipe) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *pipe) send(data []byte) error {
+	select {
+	case p.out <- data:
+		return nil
+	case <-p.closed:
+		return errors.New("controller closed the transport")
+	}
+}
+
+// replies drains the replies written so far, skipping standard input reports
+func (p *pipe) replies() [][]byte {
+	var replies [][]byte
+	for {
+		select {
+		case data := <-p.in:
+			if !isInputReport(data) {
+				replies = append(replies, data)
+			}
+		default:
+			return replies
+		}
+	}
+}
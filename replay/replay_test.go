@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package replay
+
+import (
+	"os"
+	"testing"
+
+	"github.com/lmLumos/nscon"
+)
+
+func loadHandshake(t *testing.T) []Packet {
+	t.Helper()
+	f, err := os.Open("testdata/handshake.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	packets, err := Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packets
+}
+
+func TestHandshake(t *testing.T) {
+	mismatches, err := Run(nscon.NewController("replay"), loadHandshake(t), Options{})
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	packets := loadHandshake(t)
+	// Corrupt the serial number read from SPI, the reply to the third UART request
+	step, corrupted := -1, -1
+	for i, p := range packets {
+		if p.Dir == nscon.DirOut {
+			step++
+		}
+		if step == 7 && p.Dir == nscon.DirIn {
+			p.Data = append([]byte(nil), p.Data...)
+			p.Data[20] ^= 0xff
+			packets[i] = p
+			corrupted = step
+			break
+		}
+	}
+
+	mismatches, err := Run(nscon.NewController("replay"), packets, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Step != corrupted {
+		t.Fatalf("got mismatches %v, want one at step %d", mismatches, corrupted)
+	}
+}
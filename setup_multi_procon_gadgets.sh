@@ -88,7 +88,7 @@ echo "Nintendo Switch Pro Controllers" > configs/c.1/strings/0x409/configuration
 echo $((500 * NUM_CONTROLLERS)) > configs/c.1/MaxPower  # Scale power with controller count
 echo 0xa0 > configs/c.1/bmAttributes
 
-# HID Report Descriptor
+# HID Report Descriptor, generated with: go run ./cmd/nscon gadget descriptor
 HID_REPORT_DESC="050115000904A1018530050105091901290A150025017501950A5500650081020509190B290E150025017501950481027501950281030B01000100A1000B300001000B310001000B320001000B35000100150027FFFF0000751095048102C00B39000100150025073500463B0165147504950181020509190F2912150025017501950481027508953481030600FF852109017508953F8103858109027508953F8103850109037508953F9183851009047508953F9183858009057508953F9183858209067508953F9183C0"
 
 # Create HID functions for each controller
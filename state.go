@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: GPL-3.0-only
+
+package nscon
+
+import "context"
+
+// ConnectionState is the state of the link between a Controller and the host
+type ConnectionState int
+
+const (
+	// Disconnected means the device file is not open
+	Disconnected ConnectionState = iota
+	// Handshaking means the device file is open and the host has not paired yet
+	Handshaking
+	// Paired means the host completed the 0x80 0x02 handshake
+	Paired
+	// Streaming means the host requested input reports with 0x80 0x04
+	Streaming
+	// Suspended means the host stopped input reports with 0x80 0x05
+	Suspended
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Handshaking:
+		return "handshaking"
+	case Paired:
+		return "paired"
+	case Streaming:
+		return "streaming"
+	case Suspended:
+		return "suspended"
+	}
+	return "unknown"
+}
+
+// State returns the current connection state
+func (c *Controller) State() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// WaitState blocks until the controller reaches state or ctx is done
+func (c *Controller) WaitState(ctx context.Context, state ConnectionState) error {
+	for {
+		c.mu.Lock()
+		if c.state == state {
+			c.mu.Unlock()
+			return nil
+		}
+		if c.stateChanged == nil {
+			c.stateChanged = make(chan struct{})
+		}
+		changed := c.stateChanged
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setState changes the state and reports whether it differed. c.mu must be held.
+func (c *Controller) setState(state ConnectionState) bool {
+	if c.state == state {
+		return false
+	}
+	c.state = state
+	if c.stateChanged != nil {
+		close(c.stateChanged)
+		c.stateChanged = nil
+	}
+	return true
+}
+
+// transition changes the state and emits EventStateChanged
+func (c *Controller) transition(state ConnectionState) {
+	c.mu.Lock()
+	changed := c.setState(state)
+	c.mu.Unlock()
+	if changed {
+		c.emit(Event{Kind: EventStateChanged, State: state})
+	}
+}